@@ -0,0 +1,105 @@
+// Package api writes the JSON responses of the HTTP endpoints, and the one
+// shape every error they answer with takes:
+//
+//	{"error": {"code": "not_found", "message": "Post not found",
+//	           "details": {...}, "request_id": "..."}}
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Codes for errors that need telling apart from others with the same
+// status. Any other error's code is its status text in snake case, such as
+// "not_found" or "too_many_requests".
+const (
+	CodeInvalidFields    = "invalid_fields"
+	CodeAccountSuspended = "account_suspended"
+	CodeAccountBanned    = "account_banned"
+	CodeRejected         = "rejected_by_filter"
+)
+
+// Error is an error to answer a request with. Details holds more about it,
+// mostly a message for each invalid field keyed by the field's name.
+type Error struct {
+	Status    int               `json:"-"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError returns an error with the code its status implies.
+func NewError(status int, message string) *Error {
+	return &Error{
+		Status:  status,
+		Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message: message,
+	}
+}
+
+// FieldErrors returns an error for invalid fields, with a message for each
+// in details. Its message is the field's when only one is invalid.
+func FieldErrors(status int, details map[string]string) *Error {
+	message := "Some fields are invalid"
+	if len(details) == 1 {
+		for _, m := range details {
+			message = m
+		}
+	}
+	e := NewError(status, message)
+	e.Code = CodeInvalidFields
+	e.Details = details
+	return e
+}
+
+// Internal is the error for everything that went wrong on the server's
+// side. What went wrong is logged, never sent.
+func Internal() *Error {
+	return NewError(http.StatusInternalServerError, "Internal server error")
+}
+
+// WithCode sets a code more specific than the status's.
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithDetails adds details to the error.
+func (e *Error) WithDetails(details map[string]string) *Error {
+	e.Details = details
+	return e
+}
+
+// WriteJSON answers with data encoded as JSON.
+func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("JSON encode error: %v", err)
+	}
+}
+
+// WriteError answers with err, carrying the request's ID when
+// RequestIDMiddleware gave it one.
+func WriteError(w http.ResponseWriter, err *Error) {
+	err.RequestID = w.Header().Get(RequestIDHeader)
+	WriteJSON(w, err.Status, map[string]*Error{"error": err})
+}
+
+// Fail answers with NewError(status, message).
+func Fail(w http.ResponseWriter, status int, message string) {
+	WriteError(w, NewError(status, message))
+}
+
+// FailFields answers with FieldErrors(status, details).
+func FailFields(w http.ResponseWriter, status int, details map[string]string) {
+	WriteError(w, FieldErrors(status, details))
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+
+	"real/api"
+	"real/db"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Each role includes the permissions of the roles ranked below it.
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func GetUserRole(userID string) (string, error) {
+	var role string
+	err := db.DB.QueryRow(`SELECT role FROM users WHERE user_id = ?`, userID).Scan(&role)
+	return role, err
+}
+
+// HasRole reports whether the user holds role or a role ranked above it.
+func HasRole(userID string, role string) bool {
+	current, err := GetUserRole(userID)
+	if err != nil {
+		return false
+	}
+	return roleRank[current] >= roleRank[role]
+}
+
+// RequireRole only lets requests through from signed-in users holding role or
+// a higher one. It must run inside SessionMiddleware.
+func RequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := GetUserID(r)
+		if !ok {
+			api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if !HasRole(userID, role) {
+			api.Fail(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
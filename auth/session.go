@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"real/db"
@@ -27,6 +29,11 @@ func SessionMiddleware(next http.Handler) http.Handler {
 		var expiresAt time.Time
 		query := `SELECT user_id, expires_at FROM sessions WHERE session_id = ?`
 		err = db.DB.QueryRow(query, cookie.Value).Scan(&userID, &expiresAt)
+		if err == nil && !time.Now().After(expiresAt) && sanctioned(userID) {
+			// Suspended or banned since signing in
+			db.DB.Exec(`DELETE FROM sessions WHERE session_id = ?`, cookie.Value)
+			err = sql.ErrNoRows
+		}
 		if err == sql.ErrNoRows || time.Now().After(expiresAt) {
 			// Invalid or expired session, clear the cookie
 			http.SetCookie(w, &http.Cookie{
@@ -46,6 +53,20 @@ func SessionMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// sanctioned reports whether a suspension or ban is in force on the user.
+func sanctioned(userID string) bool {
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return false
+	}
+	s, err := db.ActiveSanction(id)
+	if err != nil {
+		log.Printf("Error checking sanctions for user %s: %v", userID, err)
+		return false
+	}
+	return s != nil
+}
+
 func RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(userIDKey)
@@ -73,6 +94,19 @@ func GetUserID(r *http.Request) (string, bool) {
 	return userID, ok
 }
 
+// CurrentUserID returns the signed-in user's ID as an int.
+func CurrentUserID(r *http.Request) (int, bool) {
+	userID, ok := GetUserID(r)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
 func IsAuthenticated(r *http.Request) bool {
 	_, ok := GetUserID(r)
 	return ok
@@ -0,0 +1,169 @@
+package chat
+
+import (
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"real/auth"
+	"real/db"
+	"real/mentions"
+	"real/models"
+	"real/storage"
+	"real/uploads"
+)
+
+// AttachmentsPrefix is the storage prefix attachment files are kept
+// under. They are only ever served through OpenAttachment and
+// OpenStoredAttachment, never linked to in storage directly.
+const AttachmentsPrefix = "attachments"
+
+const maxFilenameLength = 255
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// Upload stores a file the user will send with a message. It fails with
+// uploads.ErrTooLarge or uploads.ErrUnsupportedType when the file does not
+// fit uploads.Attachments.
+func Upload(userID int, filename string, r io.Reader) (models.Attachment, error) {
+	file, err := uploads.Save(AttachmentsPrefix, r, uploads.Attachments)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	a, err := db.CreateAttachment(userID, cleanFilename(filename, file.Name), file.Name, file.ContentType, file.Size)
+	if err != nil {
+		if rmErr := storage.Media.Delete(file.Key); rmErr != nil {
+			log.Printf("Error removing attachment file: %v", rmErr)
+		}
+		return models.Attachment{}, err
+	}
+	return a, nil
+}
+
+// AttachUploads turns the user's finished resumable uploads into
+// attachments, the way Upload does, and returns their IDs for Send. Each
+// upload remembers its attachment, so a message that failed to send can be
+// sent again with the same uploads.
+func AttachUploads(userID int, uploadIDs []string) ([]int64, error) {
+	ids := make([]int64, 0, len(uploadIDs))
+	for _, uploadID := range uploadIDs {
+		u, err := uploads.GetResumable(userID, uploadID)
+		if errors.Is(err, uploads.ErrUploadNotFound) {
+			return nil, ErrUploadUnavailable
+		}
+		if err != nil {
+			return nil, err
+		}
+		if u.AttachmentID != nil {
+			ids = append(ids, *u.AttachmentID)
+			continue
+		}
+
+		u, f, err := uploads.OpenResumable(userID, uploadID, uploads.PurposeAttachment)
+		if errors.Is(err, uploads.ErrUploadNotFound) || errors.Is(err, uploads.ErrUploadIncomplete) {
+			return nil, ErrUploadUnavailable
+		}
+		if err != nil {
+			return nil, err
+		}
+		a, err := Upload(userID, u.Filename, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.SetUploadAttachment(uploadID, a.AttachmentID); err != nil {
+			return nil, err
+		}
+		uploads.RemoveResumableData(uploadID)
+		ids = append(ids, a.AttachmentID)
+	}
+	return ids, nil
+}
+
+// OpenAttachment returns an attachment and its file if the user may see it:
+// before it is sent only the uploader can, and afterwards the members of the
+// conversation, until the message is deleted. While the message is held or
+// hidden, only its sender and moderators can. The caller closes the file.
+func OpenAttachment(userID int, attachmentID int64) (models.Attachment, io.ReadSeekCloser, error) {
+	a, err := db.GetAttachment(attachmentID)
+	if errors.Is(err, db.ErrAttachmentNotFound) {
+		return models.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	return openAttachment(userID, a)
+}
+
+// OpenStoredAttachment is OpenAttachment for the attachment stored under
+// name, as its media URL refers to it.
+func OpenStoredAttachment(userID int, name string) (models.Attachment, io.ReadSeekCloser, error) {
+	a, err := db.GetAttachmentByStoredName(name)
+	if errors.Is(err, db.ErrAttachmentNotFound) {
+		return models.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	return openAttachment(userID, a)
+}
+
+func openAttachment(userID int, a models.Attachment) (models.Attachment, io.ReadSeekCloser, error) {
+	if a.MessageID == nil {
+		if a.UploaderID != userID {
+			return models.Attachment{}, nil, ErrAttachmentNotFound
+		}
+	} else {
+		msg, err := db.GetMessage(*a.MessageID)
+		if err != nil {
+			return models.Attachment{}, nil, err
+		}
+		if msg.DeletedAt != nil {
+			return models.Attachment{}, nil, ErrAttachmentNotFound
+		}
+		visibility, err := db.GetVisibility(mentions.ContentMessage, int(msg.MessageID))
+		if err != nil {
+			return models.Attachment{}, nil, err
+		}
+		if visibility != db.Visible {
+			// Held or hidden, the message is only the sender's and the
+			// moderators' to see, members or not.
+			if msg.SenderID != userID && !auth.HasRole(strconv.Itoa(userID), auth.RoleModerator) {
+				return models.Attachment{}, nil, ErrAttachmentNotFound
+			}
+		} else if err := requireMember(msg.ConversationID, userID); errors.Is(err, ErrNotMember) {
+			return models.Attachment{}, nil, ErrAttachmentNotFound
+		} else if err != nil {
+			return models.Attachment{}, nil, err
+		}
+	}
+
+	f, _, err := uploads.Open(AttachmentsPrefix, a.StoredName)
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Printf("Attachment %d has no file", a.AttachmentID)
+		return models.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+	return a, f, nil
+}
+
+// cleanFilename keeps the base of the name the client gave the file, for
+// showing to users and downloading under. Without one the stored name is
+// used.
+func cleanFilename(name, fallback string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, `\`, "/")))
+	if name == "" || name == "." || name == "/" || !utf8.ValidString(name) {
+		return fallback
+	}
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+	return name
+}
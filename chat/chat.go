@@ -0,0 +1,378 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"real/db"
+	"real/filter"
+	"real/mentions"
+	"real/models"
+	"real/notifications"
+	"real/realtime"
+)
+
+const (
+	MaxMessageLength     = 2000
+	MaxClientMsgIDLength = 64
+	MaxAttachments       = 4
+)
+
+var (
+	ErrNotMember          = errors.New("not a member of this conversation")
+	ErrEmptyMessage       = errors.New("message is empty")
+	ErrMessageTooLong     = errors.New("message is too long")
+	ErrUnknownUser        = errors.New("user not found")
+	ErrMessageYourself    = errors.New("cannot message yourself")
+	ErrInvalidClientMsgID = errors.New("client message ID is too long or was used for another conversation")
+	ErrTooManyAttachments = errors.New("too many attachments")
+	// ErrAttachmentUnavailable means an attachment was not uploaded by the
+	// sender, or was already sent.
+	ErrAttachmentUnavailable = errors.New("attachment unavailable")
+	// ErrUploadUnavailable means a resumable upload is not the sender's,
+	// not finished, or expired.
+	ErrUploadUnavailable = errors.New("upload unavailable")
+	// ErrBlocked means one of the users has blocked the other.
+	ErrBlocked = errors.New("user is blocked")
+	// ErrRejected means the content filter does not allow the message.
+	ErrRejected = errors.New("message rejected by the content filter")
+)
+
+// readReceipt is the payload of a read_receipt event.
+type readReceipt struct {
+	ConversationID    int   `json:"conversation_id"`
+	UserID            int   `json:"user_id"`
+	LastReadMessageID int64 `json:"last_read_message_id"`
+}
+
+// OpenDirect returns the one-to-one conversation between two users,
+// creating it the first time they talk.
+func OpenDirect(userID, otherID int) (models.Conversation, error) {
+	if userID == otherID {
+		return models.Conversation{}, ErrMessageYourself
+	}
+
+	exists, err := db.UserExists(otherID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if !exists {
+		return models.Conversation{}, ErrUnknownUser
+	}
+	blocked, err := db.IsBlockedEitherWay(userID, otherID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if blocked {
+		return models.Conversation{}, ErrBlocked
+	}
+
+	conversationID, err := db.GetOrCreateDirectConversation(userID, otherID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	return Get(userID, conversationID)
+}
+
+// Get returns a conversation the user is a member of.
+func Get(userID, conversationID int) (models.Conversation, error) {
+	if err := requireMember(conversationID, userID); err != nil {
+		return models.Conversation{}, err
+	}
+	return db.GetConversation(conversationID)
+}
+
+// List returns every conversation the user is a member of, most recently
+// active first.
+func List(userID int) ([]models.Conversation, error) {
+	return db.ListConversations(userID)
+}
+
+// Send stores a message from senderID and delivers it to the other members
+// of the conversation. attachmentIDs are files the sender uploaded for it;
+// with at least one, the text may be empty. clientMsgID is an optional key
+// chosen by the sender's client: sending again with the same key returns the
+// message stored the first time instead of a copy, and the returned bool is
+// false.
+//
+// Messages the content filter holds or hides are only delivered to the
+// sender until a moderator publishes them.
+func Send(senderID, conversationID int, content, clientMsgID string, attachmentIDs []int64) (models.Message, bool, error) {
+	content = strings.TrimSpace(content)
+	attachmentIDs = uniqueIDs(attachmentIDs)
+	if content == "" && len(attachmentIDs) == 0 {
+		return models.Message{}, false, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return models.Message{}, false, ErrMessageTooLong
+	}
+	if len(attachmentIDs) > MaxAttachments {
+		return models.Message{}, false, ErrTooManyAttachments
+	}
+	if len(clientMsgID) > MaxClientMsgIDLength {
+		return models.Message{}, false, ErrInvalidClientMsgID
+	}
+
+	if err := requireMember(conversationID, senderID); err != nil {
+		return models.Message{}, false, err
+	}
+	if err := requireNotBlocked(conversationID, senderID); err != nil {
+		return models.Message{}, false, err
+	}
+
+	// A retry must not reach the filter, which would take it for a
+	// duplicate.
+	if clientMsgID != "" {
+		msg, err := resent(senderID, conversationID, clientMsgID)
+		if !errors.Is(err, db.ErrMessageNotFound) {
+			return msg, false, err
+		}
+	}
+
+	verdict, err := filter.Check(senderID, content)
+	if err != nil {
+		return models.Message{}, false, err
+	}
+	if verdict.Action == filter.Reject {
+		return models.Message{}, false, ErrRejected
+	}
+
+	msg, err := db.CreateMessage(conversationID, senderID, db.MessageUser, content, clientMsgID, attachmentIDs, verdict.Visibility())
+	if errors.Is(err, db.ErrAttachmentUnavailable) {
+		return models.Message{}, false, ErrAttachmentUnavailable
+	}
+	if errors.Is(err, db.ErrDuplicateMessage) {
+		msg, err = resent(senderID, conversationID, clientMsgID)
+		return msg, false, err
+	}
+	if err != nil {
+		return models.Message{}, false, err
+	}
+	if err := filter.Remember(senderID, verdict); err != nil {
+		log.Printf("Error remembering message: %v", err)
+	}
+	// Mentions are recorded before delivery so the delivered message links
+	// them.
+	if verdict.Action == filter.Allow {
+		if err := mentions.Record(mentions.ContentMessage, int(msg.MessageID), senderID, content); err != nil {
+			log.Printf("Error recording mentions: %v", err)
+		}
+	}
+	msg = render(msg)
+
+	members, err := deliver(msg)
+	if err != nil {
+		return msg, true, err
+	}
+	if verdict.Action != filter.Allow {
+		if err := filter.Flag(mentions.ContentMessage, int(msg.MessageID), senderID, verdict); err != nil {
+			log.Printf("Error flagging message: %v", err)
+		}
+		return msg, true, nil
+	}
+	for _, m := range members {
+		if m.UserID == senderID {
+			continue
+		}
+		if err := notifications.Notify(m.UserID, senderID, notifications.KindMessage, "message", int(msg.MessageID)); err != nil {
+			log.Printf("Error sending message notification: %v", err)
+		}
+	}
+	return msg, true, nil
+}
+
+// resent returns the message the sender already sent with clientMsgID, or
+// db.ErrMessageNotFound.
+func resent(senderID, conversationID int, clientMsgID string) (models.Message, error) {
+	msg, err := db.GetMessageByClientID(senderID, clientMsgID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if msg.ConversationID != conversationID {
+		return models.Message{}, ErrInvalidClientMsgID
+	}
+	return render(msg), nil
+}
+
+// History returns a page of messages, oldest first, sent before the given
+// message ID (0 for the latest). Messages from users the reader blocked are
+// left out. The reader's own messages are marked seen once another member
+// has read them.
+func History(userID, conversationID int, before int64, limit int) ([]models.Message, error) {
+	if err := requireMember(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	messages, err := db.ListMessages(conversationID, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	seenUpTo, err := db.PeerReadMarker(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Seen = messages[i].SenderID == userID && messages[i].MessageID <= seenUpTo
+	}
+	if err := renderMessages(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// renderMessages fills in ContentHTML, linking the mentions recorded for
+// each message.
+func renderMessages(messages []models.Message) error {
+	ids := make([]int, 0, len(messages))
+	for _, m := range messages {
+		if m.DeletedAt == nil {
+			ids = append(ids, int(m.MessageID))
+		}
+	}
+
+	recorded, err := db.GetMentions(mentions.ContentMessage, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].ContentHTML = mentions.Render(messages[i].Content, recorded[int(messages[i].MessageID)])
+	}
+	return nil
+}
+
+// render returns msg with ContentHTML filled in. The message is already
+// stored, so when its mentions cannot be read it goes out without them.
+func render(msg models.Message) models.Message {
+	messages := []models.Message{msg}
+	if err := renderMessages(messages); err != nil {
+		log.Printf("Error rendering message %d: %v", msg.MessageID, err)
+	}
+	return messages[0]
+}
+
+// MarkRead moves the user's read marker up to the given message (0 for the
+// latest). The user's own connections are always told so other tabs can
+// clear their unread badge; the other members only get a read receipt when
+// the user shares read receipts.
+func MarkRead(userID, conversationID int, upTo int64) (int64, error) {
+	marker, changed, err := db.MarkConversationRead(conversationID, userID, upTo)
+	if errors.Is(err, db.ErrConversationNotFound) {
+		return 0, ErrNotMember
+	}
+	if err != nil || !changed {
+		return marker, err
+	}
+
+	receipt := readReceipt{ConversationID: conversationID, UserID: userID, LastReadMessageID: marker}
+	realtime.SendToUser(userID, realtime.EventReadReceipt, receipt)
+
+	settings, err := db.GetUserSettings(userID)
+	if err != nil {
+		return marker, err
+	}
+	if !settings.ReadReceipts {
+		return marker, nil
+	}
+
+	members, err := db.GetConversationMembers(conversationID)
+	if err != nil {
+		return marker, err
+	}
+	for _, m := range members {
+		if m.UserID != userID {
+			realtime.SendToUser(m.UserID, realtime.EventReadReceipt, receipt)
+		}
+	}
+	return marker, nil
+}
+
+// deliver pushes a message to every member of its conversation, including
+// the sender's other tabs, and returns the members.
+func deliver(msg models.Message) ([]models.ConversationMember, error) {
+	return push(msg, realtime.EventMessage)
+}
+
+// push sends an event about a message to every member of its conversation,
+// except members who blocked the sender of a user message, and returns the
+// members. A message the content filter held or hid only goes to its sender.
+func push(msg models.Message, eventType string) ([]models.ConversationMember, error) {
+	members, err := db.GetConversationMembers(msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := map[int]bool{}
+	visible := true
+	if msg.Kind == db.MessageUser {
+		if blockers, err = db.BlockerIDs(msg.SenderID); err != nil {
+			return nil, err
+		}
+		visibility, err := db.GetVisibility(mentions.ContentMessage, int(msg.MessageID))
+		if err != nil {
+			return nil, err
+		}
+		visible = visibility == db.Visible
+	}
+	for _, m := range members {
+		if !visible && m.UserID != msg.SenderID {
+			continue
+		}
+		if !blockers[m.UserID] {
+			realtime.SendToUser(m.UserID, eventType, msg)
+		}
+	}
+	return members, nil
+}
+
+// requireNotBlocked stops messages in a direct conversation where either
+// user blocked the other. In groups, blocking only hides the sender's
+// messages from the user who blocked them.
+func requireNotBlocked(conversationID, senderID int) error {
+	conversation, err := db.GetConversation(conversationID)
+	if err != nil {
+		return err
+	}
+	if conversation.Kind != db.ConversationDirect {
+		return nil
+	}
+	for _, m := range conversation.Members {
+		if m.UserID == senderID {
+			continue
+		}
+		blocked, err := db.IsBlockedEitherWay(senderID, m.UserID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+func requireMember(conversationID, userID int) error {
+	member, err := db.IsConversationMember(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotMember
+	}
+	return nil
+}
+
+func uniqueIDs(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
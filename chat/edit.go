@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"real/db"
+	"real/filter"
+	"real/mentions"
+	"real/models"
+	"real/realtime"
+)
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotSender        = errors.New("only the sender can change a message")
+	ErrEditWindowPassed = errors.New("message is too old to edit")
+)
+
+// Edit replaces the content of one of the user's own messages, as long as
+// it was sent less than window ago. The other members see it marked edited;
+// what it said before is kept for moderators.
+func Edit(userID int, messageID int64, content string, window time.Duration) (models.Message, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return models.Message{}, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(content) > MaxMessageLength {
+		return models.Message{}, ErrMessageTooLong
+	}
+
+	msg, err := ownMessage(userID, messageID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	now := time.Now()
+	if now.Sub(msg.CreatedAt) > window {
+		return models.Message{}, ErrEditWindowPassed
+	}
+	if msg.Content == content {
+		return render(msg), nil
+	}
+	// An edit others may already have seen cannot be held back, so
+	// anything the filter catches is refused.
+	verdict, err := filter.Check(userID, content)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if verdict.Action != filter.Allow {
+		return models.Message{}, ErrRejected
+	}
+
+	msg, err = db.EditMessage(messageID, content, now)
+	if err != nil {
+		return models.Message{}, messageError(err)
+	}
+	if err := filter.Remember(userID, verdict); err != nil {
+		log.Printf("Error remembering message: %v", err)
+	}
+
+	// Only users mentioned for the first time are notified, and nobody
+	// while the message is held or hidden. They are recorded before the
+	// update goes out so it links them.
+	visibility, err := db.GetVisibility(mentions.ContentMessage, int(msg.MessageID))
+	if err != nil {
+		return msg, err
+	}
+	if visibility == db.Visible {
+		if err := mentions.Record(mentions.ContentMessage, int(msg.MessageID), userID, content); err != nil {
+			log.Printf("Error recording mentions: %v", err)
+		}
+	}
+	msg = render(msg)
+	return msg, deliverUpdate(msg)
+}
+
+// Delete removes one of the user's own messages for everyone. It stays in
+// the conversation as a tombstone with no content.
+func Delete(userID int, messageID int64) (models.Message, error) {
+	if _, err := ownMessage(userID, messageID); err != nil {
+		return models.Message{}, err
+	}
+
+	msg, err := db.DeleteMessage(messageID, time.Now())
+	if err != nil {
+		return models.Message{}, messageError(err)
+	}
+	return msg, deliverUpdate(msg)
+}
+
+// Remove deletes any user's message for everyone on a moderator's behalf,
+// leaving the same tombstone as Delete.
+func Remove(messageID int64) (models.Message, error) {
+	msg, err := db.DeleteMessage(messageID, time.Now())
+	if err != nil {
+		return models.Message{}, messageError(err)
+	}
+	return msg, deliverUpdate(msg)
+}
+
+// Publish makes a message the content filter held or hid visible on a
+// moderator's behalf and delivers it to the other members as if it had just
+// been sent.
+func Publish(messageID int64) error {
+	published, err := db.Publish(mentions.ContentMessage, int(messageID))
+	if err != nil || !published {
+		return err
+	}
+	msg, err := db.GetMessage(messageID)
+	if err != nil {
+		return messageError(err)
+	}
+	_, err = deliver(render(msg))
+	return err
+}
+
+// ownMessage returns a message the user sent and has not deleted. Messages
+// in conversations the user is no longer part of are reported as not found.
+func ownMessage(userID int, messageID int64) (models.Message, error) {
+	msg, err := db.GetMessage(messageID)
+	if err != nil {
+		return models.Message{}, messageError(err)
+	}
+	if err := requireMember(msg.ConversationID, userID); errors.Is(err, ErrNotMember) {
+		return models.Message{}, ErrMessageNotFound
+	} else if err != nil {
+		return models.Message{}, err
+	}
+	if msg.DeletedAt != nil {
+		return models.Message{}, ErrMessageNotFound
+	}
+	if msg.SenderID != userID || msg.Kind != db.MessageUser {
+		return models.Message{}, ErrNotSender
+	}
+	return msg, nil
+}
+
+func messageError(err error) error {
+	if errors.Is(err, db.ErrMessageNotFound) {
+		return ErrMessageNotFound
+	}
+	return err
+}
+
+// deliverUpdate pushes the new state of a message to every member of its
+// conversation.
+func deliverUpdate(msg models.Message) error {
+	_, err := push(msg, realtime.EventMessageUpdated)
+	return err
+}
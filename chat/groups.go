@@ -0,0 +1,250 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"real/db"
+	"real/models"
+	"real/realtime"
+)
+
+const (
+	MaxGroupMembers = 50
+	MaxTitleLength  = 100
+)
+
+var (
+	ErrNotOwner       = errors.New("only the owner can do this")
+	ErrNotGroup       = errors.New("not a group conversation")
+	ErrInvalidTitle   = errors.New("title is empty or too long")
+	ErrTooManyMembers = errors.New("too many members")
+	ErrNoMembers      = errors.New("no users given")
+	ErrUserNotMember  = errors.New("user is not a member of this conversation")
+)
+
+// CreateGroup starts a group conversation owned by ownerID with the given
+// users.
+func CreateGroup(ownerID int, title string, memberIDs []int) (models.Conversation, error) {
+	title, err := validTitle(title)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	names, others, err := resolveUsers(ownerID, memberIDs)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if len(others)+1 > MaxGroupMembers {
+		return models.Conversation{}, ErrTooManyMembers
+	}
+
+	conversationID, err := db.CreateGroupConversation(ownerID, title, others)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	text := fmt.Sprintf("%s created the group %q", names[ownerID], title)
+	if len(others) > 0 {
+		text += " with " + joinNames(names, others)
+	}
+	return announce(conversationID, ownerID, text, nil)
+}
+
+// AddMembers lets the owner of a group add users to it. Users who are
+// already members are skipped.
+func AddMembers(actorID, conversationID int, userIDs []int) (models.Conversation, error) {
+	if _, err := requireOwner(actorID, conversationID); err != nil {
+		return models.Conversation{}, err
+	}
+
+	names, others, err := resolveUsers(actorID, userIDs)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if len(others) == 0 {
+		return models.Conversation{}, ErrNoMembers
+	}
+
+	count, err := db.CountConversationMembers(conversationID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if count+len(others) > MaxGroupMembers {
+		return models.Conversation{}, ErrTooManyMembers
+	}
+
+	added, err := db.AddConversationMembers(conversationID, others)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if len(added) == 0 {
+		return db.GetConversation(conversationID)
+	}
+
+	text := fmt.Sprintf("%s added %s", names[actorID], joinNames(names, added))
+	return announce(conversationID, actorID, text, nil)
+}
+
+// RemoveMember takes userID out of a group. Members can remove themselves,
+// which is how they leave; only the owner can remove anyone else. When the
+// owner leaves, the longest-standing member becomes the owner.
+func RemoveMember(actorID, conversationID, userID int) (models.Conversation, error) {
+	if actorID != userID {
+		if _, err := requireOwner(actorID, conversationID); err != nil {
+			return models.Conversation{}, err
+		}
+	} else if _, err := requireGroup(actorID, conversationID); err != nil {
+		return models.Conversation{}, err
+	}
+
+	names, err := db.GetUsernames([]int{actorID, userID})
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	removed, err := db.RemoveConversationMember(conversationID, userID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if !removed {
+		return models.Conversation{}, ErrUserNotMember
+	}
+
+	text := fmt.Sprintf("%s left", names[userID])
+	if actorID != userID {
+		text = fmt.Sprintf("%s removed %s", names[actorID], names[userID])
+	}
+	// The removed user is no longer a member, so tell them separately.
+	return announce(conversationID, actorID, text, []int{userID})
+}
+
+// Rename lets the owner of a group change its title.
+func Rename(actorID, conversationID int, title string) (models.Conversation, error) {
+	title, err := validTitle(title)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	conversation, err := requireOwner(actorID, conversationID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	if conversation.Title == title {
+		return conversation, nil
+	}
+
+	if err := db.RenameConversation(conversationID, title); err != nil {
+		return models.Conversation{}, err
+	}
+
+	names, err := db.GetUsernames([]int{actorID})
+	if err != nil {
+		return models.Conversation{}, err
+	}
+	text := fmt.Sprintf("%s renamed the group to %q", names[actorID], title)
+	return announce(conversationID, actorID, text, nil)
+}
+
+// announce posts a system message about a change to a conversation, then
+// sends the updated conversation to its members and to anyone in alsoNotify.
+func announce(conversationID, actorID int, text string, alsoNotify []int) (models.Conversation, error) {
+	msg, err := db.CreateMessage(conversationID, actorID, db.MessageSystem, text, "", nil, db.Visible)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	conversation, err := db.GetConversation(conversationID)
+	if err != nil {
+		return models.Conversation{}, err
+	}
+
+	for _, m := range conversation.Members {
+		realtime.SendToUser(m.UserID, realtime.EventConversation, conversation)
+	}
+	for _, userID := range alsoNotify {
+		realtime.SendToUser(userID, realtime.EventConversation, conversation)
+	}
+	if _, err := deliver(msg); err != nil {
+		log.Printf("Error delivering system message: %v", err)
+	}
+
+	return conversation, nil
+}
+
+func requireGroup(userID, conversationID int) (models.Conversation, error) {
+	conversation, err := Get(userID, conversationID)
+	if err != nil {
+		return conversation, err
+	}
+	if conversation.Kind != db.ConversationGroup {
+		return conversation, ErrNotGroup
+	}
+	return conversation, nil
+}
+
+func requireOwner(userID, conversationID int) (models.Conversation, error) {
+	conversation, err := requireGroup(userID, conversationID)
+	if err != nil {
+		return conversation, err
+	}
+	if conversation.OwnerID != userID {
+		return conversation, ErrNotOwner
+	}
+	return conversation, nil
+}
+
+// resolveUsers looks up the actor and the given users, dropping the actor
+// and duplicates from the list. Any unknown ID fails with ErrUnknownUser,
+// and a user who blocked the actor with ErrBlocked.
+func resolveUsers(actorID int, userIDs []int) (map[int]string, []int, error) {
+	seen := map[int]bool{actorID: true}
+	var others []int
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+
+	names, err := db.GetUsernames(append([]int{actorID}, others...))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range others {
+		if _, ok := names[id]; !ok {
+			return nil, nil, ErrUnknownUser
+		}
+		// Nobody can be pulled into a group by someone they blocked.
+		blocked, err := db.IsBlocked(id, actorID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if blocked {
+			return nil, nil, ErrBlocked
+		}
+	}
+	return names, others, nil
+}
+
+func validTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > MaxTitleLength {
+		return "", ErrInvalidTitle
+	}
+	return title, nil
+}
+
+func joinNames(names map[int]string, ids []int) string {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = names[id]
+	}
+	if len(list) == 1 {
+		return list[0]
+	}
+	return strings.Join(list[:len(list)-1], ", ") + " and " + list[len(list)-1]
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Config holds the tunable settings of the forum. Anything config.json leaves
+// out keeps its default value.
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr       string           `json:"addr"`
+	Comments   CommentsConfig   `json:"comments"`
+	Chat       ChatConfig       `json:"chat"`
+	Realtime   RealtimeConfig   `json:"realtime"`
+	Filter     FilterConfig     `json:"filter"`
+	RateLimits RateLimitsConfig `json:"rate_limits"`
+	Storage    StorageConfig    `json:"storage"`
+	Uploads    UploadsConfig    `json:"uploads"`
+}
+
+type CommentsConfig struct {
+	// MaxDepth is how many levels of replies a top-level comment can have.
+	MaxDepth int `json:"max_depth"`
+	// PageSize is the default number of top-level comments per page.
+	PageSize int `json:"page_size"`
+}
+
+type ChatConfig struct {
+	// EditWindowMinutes is how long after sending a message its sender can
+	// still edit it.
+	EditWindowMinutes int `json:"edit_window_minutes"`
+}
+
+type RealtimeConfig struct {
+	// ReplayWindowHours is how long events are kept so reconnecting clients
+	// can catch up on what they missed.
+	ReplayWindowHours int `json:"replay_window_hours"`
+	// Broker is "memory" for a single process, or "sqlite" when several
+	// processes share the database and must share real-time events.
+	Broker string `json:"broker"`
+	// PollIntervalMs is how often the sqlite broker checks for new events.
+	PollIntervalMs int `json:"poll_interval_ms"`
+}
+
+// FilterConfig tunes the content filter checks that are not admin-editable
+// rules. Actions are "reject", "hold" or "shadow".
+type FilterConfig struct {
+	// NewAccountHours is how long an account counts as new.
+	NewAccountHours int `json:"new_account_hours"`
+	// NewAccountMaxLinks is how many links a new account may post at once
+	// before LinkAction applies.
+	NewAccountMaxLinks int    `json:"new_account_max_links"`
+	LinkAction         string `json:"link_action"`
+	// DuplicateWindowMinutes is how long a user cannot post the same text
+	// again without DuplicateAction applying. 0 turns the check off.
+	DuplicateWindowMinutes int `json:"duplicate_window_minutes"`
+	// DuplicateMinLength is the shortest text checked for duplicates, so
+	// replies like "thanks" can be repeated.
+	DuplicateMinLength int    `json:"duplicate_min_length"`
+	DuplicateAction    string `json:"duplicate_action"`
+}
+
+// RateLimitsConfig holds how often a user may do each rate-limited action.
+type RateLimitsConfig struct {
+	Post    RateLimit `json:"post"`
+	Comment RateLimit `json:"comment"`
+	Vote    RateLimit `json:"vote"`
+	Message RateLimit `json:"message"`
+}
+
+// RateLimit is a token bucket: a user can act Burst times in a row, and
+// gets PerMinute more tries back every minute. A Burst of 0 turns the limit
+// off.
+type RateLimit struct {
+	Burst     int     `json:"burst"`
+	PerMinute float64 `json:"per_minute"`
+}
+
+type UploadsConfig struct {
+	// ResumableDir is the local directory the data of resumable uploads is
+	// kept in until they are used. Every process must see the same one.
+	ResumableDir string `json:"resumable_dir"`
+	// ResumableExpiryHours is how long a resumable upload is kept after
+	// data last arrived for it.
+	ResumableExpiryHours int `json:"resumable_expiry_hours"`
+	// MaxOpenResumable is how many resumable uploads a user can have at
+	// once.
+	MaxOpenResumable int `json:"max_open_resumable"`
+}
+
+// StorageConfig says where uploaded media is kept.
+type StorageConfig struct {
+	// Backend is "local" for a directory on this host, or "s3" for an
+	// S3-compatible object store.
+	Backend string             `json:"backend"`
+	Local   LocalStorageConfig `json:"local"`
+	S3      S3StorageConfig    `json:"s3"`
+}
+
+type LocalStorageConfig struct {
+	// Root is the directory media is kept under.
+	Root string `json:"root"`
+}
+
+type S3StorageConfig struct {
+	// Endpoint is the store's base URL, such as https://s3.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// PathStyle puts the bucket in the path instead of the host name, as
+	// most self-hosted stores expect.
+	PathStyle bool `json:"path_style"`
+	// PublicURL, when set, is where the bucket can be read without
+	// signing, and media links point there. Otherwise links are signed and
+	// expire after URLExpiryMinutes.
+	PublicURL        string `json:"public_url"`
+	URLExpiryMinutes int    `json:"url_expiry_minutes"`
+}
+
+// Current is the configuration in use. It holds the defaults until Load runs.
+var Current = Default()
+
+func Default() Config {
+	return Config{
+		Addr: ":8081",
+		Comments: CommentsConfig{
+			MaxDepth: 5,
+			PageSize: 20,
+		},
+		Chat: ChatConfig{
+			EditWindowMinutes: 15,
+		},
+		Realtime: RealtimeConfig{
+			ReplayWindowHours: 24,
+			Broker:            "memory",
+			PollIntervalMs:    100,
+		},
+		Filter: FilterConfig{
+			NewAccountHours:        24,
+			NewAccountMaxLinks:     2,
+			LinkAction:             "hold",
+			DuplicateWindowMinutes: 10,
+			DuplicateMinLength:     20,
+			DuplicateAction:        "reject",
+		},
+		RateLimits: RateLimitsConfig{
+			Post:    RateLimit{Burst: 5, PerMinute: 1},
+			Comment: RateLimit{Burst: 10, PerMinute: 6},
+			Vote:    RateLimit{Burst: 30, PerMinute: 60},
+			Message: RateLimit{Burst: 20, PerMinute: 30},
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+			Local:   LocalStorageConfig{Root: "media"},
+			S3:      S3StorageConfig{Region: "us-east-1", URLExpiryMinutes: 60},
+		},
+		Uploads: UploadsConfig{
+			ResumableDir:         "resumable-uploads",
+			ResumableExpiryHours: 24,
+			MaxOpenResumable:     10,
+		},
+	}
+}
+
+// Load reads path over the defaults. A missing file is not an error.
+func Load(path string) error {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		Current = cfg
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	if cfg.Comments.MaxDepth < 0 {
+		return fmt.Errorf("comments.max_depth must not be negative")
+	}
+	if cfg.Comments.PageSize <= 0 {
+		return fmt.Errorf("comments.page_size must be positive")
+	}
+	if cfg.Chat.EditWindowMinutes < 0 {
+		return fmt.Errorf("chat.edit_window_minutes must not be negative")
+	}
+	if cfg.Realtime.ReplayWindowHours <= 0 {
+		return fmt.Errorf("realtime.replay_window_hours must be positive")
+	}
+	if cfg.Realtime.Broker != "memory" && cfg.Realtime.Broker != "sqlite" {
+		return fmt.Errorf(`realtime.broker must be "memory" or "sqlite"`)
+	}
+	if cfg.Realtime.PollIntervalMs <= 0 {
+		return fmt.Errorf("realtime.poll_interval_ms must be positive")
+	}
+	if cfg.Filter.NewAccountHours < 0 {
+		return fmt.Errorf("filter.new_account_hours must not be negative")
+	}
+	if cfg.Filter.NewAccountMaxLinks < 0 {
+		return fmt.Errorf("filter.new_account_max_links must not be negative")
+	}
+	if cfg.Filter.DuplicateWindowMinutes < 0 {
+		return fmt.Errorf("filter.duplicate_window_minutes must not be negative")
+	}
+	if cfg.Filter.DuplicateMinLength < 0 {
+		return fmt.Errorf("filter.duplicate_min_length must not be negative")
+	}
+	for name, action := range map[string]string{
+		"filter.link_action":      cfg.Filter.LinkAction,
+		"filter.duplicate_action": cfg.Filter.DuplicateAction,
+	} {
+		if action != "reject" && action != "hold" && action != "shadow" {
+			return fmt.Errorf(`%s must be "reject", "hold" or "shadow"`, name)
+		}
+	}
+
+	for name, limit := range map[string]RateLimit{
+		"rate_limits.post":    cfg.RateLimits.Post,
+		"rate_limits.comment": cfg.RateLimits.Comment,
+		"rate_limits.vote":    cfg.RateLimits.Vote,
+		"rate_limits.message": cfg.RateLimits.Message,
+	} {
+		if limit.Burst < 0 {
+			return fmt.Errorf("%s.burst must not be negative", name)
+		}
+		if limit.Burst > 0 && limit.PerMinute <= 0 {
+			return fmt.Errorf("%s.per_minute must be positive", name)
+		}
+	}
+
+	if err := cfg.Storage.validate(); err != nil {
+		return err
+	}
+
+	if cfg.Uploads.ResumableDir == "" {
+		return fmt.Errorf("uploads.resumable_dir is required")
+	}
+	if cfg.Uploads.ResumableExpiryHours <= 0 {
+		return fmt.Errorf("uploads.resumable_expiry_hours must be positive")
+	}
+	if cfg.Uploads.MaxOpenResumable <= 0 {
+		return fmt.Errorf("uploads.max_open_resumable must be positive")
+	}
+
+	Current = cfg
+	return nil
+}
+
+func (c StorageConfig) validate() error {
+	switch c.Backend {
+	case "local":
+		if c.Local.Root == "" {
+			return fmt.Errorf("storage.local.root is required")
+		}
+	case "s3":
+		return c.S3.Validate()
+	default:
+		return fmt.Errorf(`storage.backend must be "local" or "s3"`)
+	}
+	return nil
+}
+
+// Validate checks that the settings are enough to reach a bucket. It is
+// also used when S3 is only the target of a migration.
+func (c S3StorageConfig) Validate() error {
+	for name, value := range map[string]string{
+		"endpoint":   c.Endpoint,
+		"region":     c.Region,
+		"bucket":     c.Bucket,
+		"access_key": c.AccessKey,
+		"secret_key": c.SecretKey,
+	} {
+		if value == "" {
+			return fmt.Errorf("storage.s3.%s is required", name)
+		}
+	}
+	if c.PublicURL == "" && c.URLExpiryMinutes <= 0 {
+		return fmt.Errorf("storage.s3.url_expiry_minutes must be positive")
+	}
+	return nil
+}
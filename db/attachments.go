@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+
+	"real/models"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	// ErrAttachmentUnavailable means an attachment to send was not uploaded
+	// by the sender or was already sent with another message.
+	ErrAttachmentUnavailable = errors.New("attachment unavailable")
+)
+
+const attachmentColumns = `
+	attachment_id, uploader_id, message_id, filename, stored_name, content_type, size, created_at`
+
+func scanAttachment(row rowScanner) (models.Attachment, error) {
+	var a models.Attachment
+	err := row.Scan(&a.AttachmentID, &a.UploaderID, &a.MessageID, &a.Filename, &a.StoredName,
+		&a.ContentType, &a.Size, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, ErrAttachmentNotFound
+	}
+	// Attachments are served from /media/ whichever storage holds them, so
+	// the server can check who is asking.
+	a.URL = "/media/attachments/" + url.PathEscape(a.StoredName)
+	return a, err
+}
+
+// CreateAttachment records a file a user uploaded to send with a message
+// later.
+func CreateAttachment(uploaderID int, filename, storedName, contentType string, size int64) (models.Attachment, error) {
+	result, err := DB.Exec(`
+		INSERT INTO message_attachments (uploader_id, filename, stored_name, content_type, size)
+		VALUES (?, ?, ?, ?, ?)`,
+		uploaderID, filename, storedName, contentType, size,
+	)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	return GetAttachment(id)
+}
+
+func GetAttachment(attachmentID int64) (models.Attachment, error) {
+	return scanAttachment(DB.QueryRow(
+		`SELECT `+attachmentColumns+` FROM message_attachments WHERE attachment_id = ?`, attachmentID,
+	))
+}
+
+func GetAttachmentByStoredName(storedName string) (models.Attachment, error) {
+	return scanAttachment(DB.QueryRow(
+		`SELECT `+attachmentColumns+` FROM message_attachments WHERE stored_name = ?`, storedName,
+	))
+}
+
+// attachFiles links uploaded attachments to a message that is being sent.
+// Every one of them must belong to the sender and not be sent yet.
+func attachFiles(tx *sql.Tx, messageID int64, senderID int, attachmentIDs []int64) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+
+	args := []interface{}{messageID, senderID}
+	for _, id := range attachmentIDs {
+		args = append(args, id)
+	}
+	result, err := tx.Exec(`
+		UPDATE message_attachments SET message_id = ?
+		WHERE uploader_id = ? AND message_id IS NULL
+		AND attachment_id IN (`+placeholders(len(attachmentIDs))+`)`, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n != int64(len(attachmentIDs)) {
+		return ErrAttachmentUnavailable
+	}
+	return nil
+}
+
+// loadAttachments fills in the attachments of each message. Deleted
+// messages get none.
+func loadAttachments(messages []models.Message) error {
+	ids := make([]interface{}, 0, len(messages))
+	for i := range messages {
+		messages[i].Attachments = []models.Attachment{}
+		if messages[i].DeletedAt == nil {
+			ids = append(ids, messages[i].MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+attachmentColumns+` FROM message_attachments
+		WHERE message_id IN (`+placeholders(len(ids))+`)
+		ORDER BY attachment_id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byMessage := make(map[int64][]models.Attachment)
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		byMessage[*a.MessageID] = append(byMessage[*a.MessageID], a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if found, ok := byMessage[messages[i].MessageID]; ok {
+			messages[i].Attachments = found
+		}
+	}
+	return nil
+}
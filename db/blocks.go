@@ -0,0 +1,129 @@
+package db
+
+import "real/models"
+
+// IsBlocked reports whether blockerID has blocked blockedID.
+func IsBlocked(blockerID, blockedID int) (bool, error) {
+	var blocked bool
+	err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)`,
+		blockerID, blockedID,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+// IsBlockedEitherWay reports whether either user has blocked the other.
+func IsBlockedEitherWay(a, b int) (bool, error) {
+	var blocked bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_blocks
+		WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))`,
+		a, b, b, a,
+	).Scan(&blocked)
+	return blocked, err
+}
+
+// Block adds blockedID to blockerID's block list. Blocking someone twice is
+// not an error.
+func Block(blockerID, blockedID int) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id) VALUES (?, ?)`,
+		blockerID, blockedID,
+	)
+	return err
+}
+
+func Unblock(blockerID, blockedID int) error {
+	_, err := DB.Exec(
+		`DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?`,
+		blockerID, blockedID,
+	)
+	return err
+}
+
+// Mute hides mutedID's posts from muterID's feed.
+func Mute(muterID, mutedID int) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO user_mutes (muter_id, muted_id) VALUES (?, ?)`,
+		muterID, mutedID,
+	)
+	return err
+}
+
+func Unmute(muterID, mutedID int) error {
+	_, err := DB.Exec(
+		`DELETE FROM user_mutes WHERE muter_id = ? AND muted_id = ?`,
+		muterID, mutedID,
+	)
+	return err
+}
+
+// ListBlocks returns the users userID has blocked, most recent first.
+func ListBlocks(userID int) ([]models.BlockedUser, error) {
+	return listBlockedUsers(`
+		SELECT u.user_id, u.username, b.created_at
+		FROM user_blocks b JOIN users u ON u.user_id = b.blocked_id
+		WHERE b.blocker_id = ? ORDER BY b.created_at DESC, u.user_id`, userID)
+}
+
+// ListMutes returns the users userID has muted, most recent first.
+func ListMutes(userID int) ([]models.BlockedUser, error) {
+	return listBlockedUsers(`
+		SELECT u.user_id, u.username, m.created_at
+		FROM user_mutes m JOIN users u ON u.user_id = m.muted_id
+		WHERE m.muter_id = ? ORDER BY m.created_at DESC, u.user_id`, userID)
+}
+
+func listBlockedUsers(query string, userID int) ([]models.BlockedUser, error) {
+	rows, err := DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.BlockedUser{}
+	for rows.Next() {
+		var u models.BlockedUser
+		if err := rows.Scan(&u.UserID, &u.Username, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// BlockedUserIDs returns the users that userID has blocked.
+func BlockedUserIDs(userID int) (map[int]bool, error) {
+	return userIDSet(`SELECT blocked_id FROM user_blocks WHERE blocker_id = ?`, userID)
+}
+
+// BlockerIDs returns the users who have blocked userID.
+func BlockerIDs(userID int) (map[int]bool, error) {
+	return userIDSet(`SELECT blocker_id FROM user_blocks WHERE blocked_id = ?`, userID)
+}
+
+// HidingUserIDs returns the users who blocked or muted userID, and so should
+// not see what userID posts.
+func HidingUserIDs(userID int) (map[int]bool, error) {
+	return userIDSet(`
+		SELECT blocker_id FROM user_blocks WHERE blocked_id = ?
+		UNION SELECT muter_id FROM user_mutes WHERE muted_id = ?`, userID, userID)
+}
+
+func userIDSet(query string, args ...interface{}) (map[int]bool, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryInUse    = errors.New("category has posts")
+)
+
+const categoryColumns = `
+	c.category_id, c.name, COALESCE(c.slug, ''), COALESCE(c.description, ''),
+	c.sort_order, c.archived,
+	(SELECT COUNT(*) FROM post_categories pc WHERE pc.category_id = c.category_id),
+	(SELECT MAX(p.created_at) FROM posts p
+	 JOIN post_categories pc ON pc.post_id = p.post_id
+	 WHERE pc.category_id = c.category_id),
+	(SELECT MAX(cm.created_at) FROM comments cm
+	 JOIN post_categories pc ON pc.post_id = cm.post_id
+	 WHERE pc.category_id = c.category_id)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCategory(row rowScanner) (models.Category, error) {
+	var (
+		c                     models.Category
+		lastPost, lastComment sql.NullString
+	)
+	err := row.Scan(&c.CategoryID, &c.Name, &c.Slug, &c.Description,
+		&c.SortOrder, &c.Archived, &c.PostCount, &lastPost, &lastComment)
+	if err != nil {
+		return c, err
+	}
+
+	for _, s := range []sql.NullString{lastPost, lastComment} {
+		if !s.Valid {
+			continue
+		}
+		t, err := parseTime(s.String)
+		if err != nil {
+			return c, err
+		}
+		if c.LastActivity == nil || t.After(*c.LastActivity) {
+			c.LastActivity = &t
+		}
+	}
+	return c, nil
+}
+
+// ListCategories returns categories in display order together with their
+// post counts and the time of the latest post or comment in each.
+func ListCategories(includeArchived bool) ([]models.Category, error) {
+	query := `SELECT ` + categoryColumns + ` FROM categories c`
+	if !includeArchived {
+		query += ` WHERE c.archived = 0`
+	}
+	query += ` ORDER BY c.sort_order, c.name`
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error executing query: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		c, err := scanCategory(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return categories, nil
+}
+
+func GetCategory(id int) (models.Category, error) {
+	c, err := scanCategory(DB.QueryRow(`SELECT `+categoryColumns+` FROM categories c WHERE c.category_id = ?`, id))
+	if err == sql.ErrNoRows {
+		return c, ErrCategoryNotFound
+	}
+	return c, err
+}
+
+// CategoryConflicts reports whether another category already uses name or
+// slug. excludeID skips the category being edited.
+func CategoryConflicts(name, slug string, excludeID int) (nameTaken, slugTaken bool, err error) {
+	err = DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM categories WHERE name = ? AND category_id != ?),
+		       EXISTS(SELECT 1 FROM categories WHERE slug = ? AND category_id != ?)`,
+		name, excludeID, slug, excludeID,
+	).Scan(&nameTaken, &slugTaken)
+	return nameTaken, slugTaken, err
+}
+
+func CreateCategory(c *models.Category) error {
+	result, err := DB.Exec(
+		`INSERT INTO categories (name, slug, description, sort_order, archived) VALUES (?, ?, ?, ?, ?)`,
+		c.Name, c.Slug, c.Description, c.SortOrder, c.Archived,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.CategoryID = int(id)
+	return nil
+}
+
+func UpdateCategory(c models.Category) error {
+	result, err := DB.Exec(`
+		UPDATE categories
+		SET name = ?, slug = ?, description = ?, sort_order = ?, archived = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE category_id = ?`,
+		c.Name, c.Slug, c.Description, c.SortOrder, c.Archived, c.CategoryID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// DeleteCategory removes a category that no post uses. Categories with posts
+// have to be archived instead so existing threads keep their labels.
+func DeleteCategory(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var inUse bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM post_categories WHERE category_id = ?)`, id).Scan(&inUse); err != nil {
+		return err
+	}
+	if inUse {
+		return ErrCategoryInUse
+	}
+
+	result, err := tx.Exec(`DELETE FROM categories WHERE category_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrCategoryNotFound
+	}
+	return tx.Commit()
+}
+
+// CheckPostCategories splits ids into the ones that do not exist and the ones
+// that are archived. Both are invalid targets for a new post.
+func CheckPostCategories(ids []int) (unknown, archived []int, err error) {
+	for _, id := range ids {
+		var isArchived bool
+		err := DB.QueryRow(`SELECT archived FROM categories WHERE category_id = ?`, id).Scan(&isArchived)
+		switch {
+		case err == sql.ErrNoRows:
+			unknown = append(unknown, id)
+		case err != nil:
+			return nil, nil, err
+		case isArchived:
+			archived = append(archived, id)
+		}
+	}
+	return unknown, archived, nil
+}
@@ -0,0 +1,266 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"real/models"
+)
+
+// DeletedCommentPlaceholder replaces the text of a deleted comment that still
+// has replies, so the thread below it keeps its context.
+const DeletedCommentPlaceholder = "[deleted]"
+
+var (
+	ErrPostNotFound    = errors.New("post not found")
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrParentDeleted   = errors.New("parent comment was deleted")
+	ErrMaxDepth        = errors.New("maximum reply depth reached")
+	// ErrReplyBlocked means the author of the post or of the comment being
+	// replied to has blocked the commenter.
+	ErrReplyBlocked = errors.New("blocked from replying")
+)
+
+const commentColumns = `
+	c.comment_id, c.post_id, c.user_id, u.username, c.parent_comment_id,
+	c.depth, c.content, c.deleted_at IS NOT NULL, c.created_at, c.updated_at, c.visibility = 'held'`
+
+func scanComment(row rowScanner) (*models.Comment, error) {
+	var (
+		c        models.Comment
+		parentID sql.NullInt64
+	)
+	err := row.Scan(&c.CommentID, &c.PostID, &c.UserID, &c.Username, &parentID,
+		&c.Depth, &c.Content, &c.Deleted, &c.CreatedAt, &c.UpdatedAt, &c.Held)
+	if err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		c.ParentID = &id
+	}
+	if c.Deleted {
+		c.UserID = 0
+		c.Username = ""
+		c.Content = DeletedCommentPlaceholder
+	}
+	return &c, nil
+}
+
+func GetComment(commentID int) (*models.Comment, error) {
+	c, err := scanComment(DB.QueryRow(`
+		SELECT `+commentColumns+`
+		FROM comments c JOIN users u ON u.user_id = c.user_id
+		WHERE c.comment_id = ?`, commentID))
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	return c, err
+}
+
+// CreateComment adds a comment to a post, or a reply when parentID is set,
+// with the given visibility. Replies nested deeper than maxDepth are
+// rejected with ErrMaxDepth, and comments under a post or comment whose
+// author blocked userID with ErrReplyBlocked. Posts and comments that userID
+// cannot see count as not found.
+func CreateComment(postID, userID int, parentID *int, content string, maxDepth int, visibility string) (*models.Comment, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var postAuthor int
+	err = tx.QueryRow(
+		`SELECT user_id FROM posts WHERE post_id = ? AND (visibility = 'visible' OR user_id = ?)`, postID, userID,
+	).Scan(&postAuthor)
+	if err == sql.ErrNoRows {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	authors := []int{postAuthor}
+
+	depth := 0
+	if parentID != nil {
+		var (
+			parentPost   int
+			parentAuthor int
+			parentDepth  int
+			deleted      bool
+		)
+		err := tx.QueryRow(
+			`SELECT post_id, user_id, depth, deleted_at IS NOT NULL FROM comments
+			WHERE comment_id = ? AND (visibility = 'visible' OR user_id = ?)`, *parentID, userID,
+		).Scan(&parentPost, &parentAuthor, &parentDepth, &deleted)
+		if err == sql.ErrNoRows || (err == nil && parentPost != postID) {
+			return nil, ErrCommentNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			return nil, ErrParentDeleted
+		}
+		depth = parentDepth + 1
+		if depth > maxDepth {
+			return nil, ErrMaxDepth
+		}
+		authors = append(authors, parentAuthor)
+	}
+
+	for _, authorID := range authors {
+		var blocked bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)`, authorID, userID,
+		).Scan(&blocked); err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrReplyBlocked
+		}
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO comments (post_id, user_id, parent_comment_id, depth, content, visibility) VALUES (?, ?, ?, ?, ?, ?)`,
+		postID, userID, parentID, depth, content, visibility,
+	)
+	if err != nil {
+		return nil, err
+	}
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return GetComment(int(commentID))
+}
+
+// CountCommentThreads returns the number of top-level comments on a post
+// that viewerID (0 for guests) can see.
+func CountCommentThreads(postID, viewerID int) (int, error) {
+	var n int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM comments
+		WHERE post_id = ? AND parent_comment_id IS NULL AND (visibility = 'visible' OR user_id = ?)`,
+		postID, viewerID,
+	).Scan(&n)
+	return n, err
+}
+
+// ListCommentThreads returns one page of the top-level comments on a post
+// that viewerID (0 for guests) can see, each followed by the replies it can
+// see. The list is in thread order: every comment comes right after its
+// parent and siblings are oldest first.
+func ListCommentThreads(postID, viewerID, limit, offset int) ([]*models.Comment, error) {
+	rows, err := DB.Query(`
+		WITH RECURSIVE roots AS (
+			SELECT comment_id FROM comments
+			WHERE post_id = ? AND parent_comment_id IS NULL AND (visibility = 'visible' OR user_id = ?)
+			ORDER BY comment_id
+			LIMIT ? OFFSET ?
+		),
+		thread(comment_id, path) AS (
+			SELECT comment_id, printf('%010d', comment_id) FROM roots
+			UNION ALL
+			SELECT c.comment_id, t.path || '/' || printf('%010d', c.comment_id)
+			FROM comments c JOIN thread t ON c.parent_comment_id = t.comment_id
+			WHERE c.post_id = ? AND (c.visibility = 'visible' OR c.user_id = ?)
+		)
+		SELECT `+commentColumns+`
+		FROM thread t
+		JOIN comments c ON c.comment_id = t.comment_id
+		JOIN users u ON u.user_id = c.user_id
+		ORDER BY t.path`,
+		postID, viewerID, limit, offset, postID, viewerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*models.Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// DeleteComment removes a comment. A comment that has replies is kept as a
+// "[deleted]" placeholder instead, so the replies below it still make sense.
+// Placeholders left without any replies are removed along the way.
+func DeleteComment(commentID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM comments WHERE comment_id = ? AND deleted_at IS NULL)`, commentID,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCommentNotFound
+	}
+
+	id := commentID
+	for {
+		var (
+			hasReplies bool
+			parentID   sql.NullInt64
+		)
+		if err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM comments WHERE parent_comment_id = ?), parent_comment_id
+			FROM comments WHERE comment_id = ?`, id, id,
+		).Scan(&hasReplies, &parentID); err != nil {
+			return err
+		}
+
+		if hasReplies {
+			if id == commentID {
+				if _, err := tx.Exec(`
+					UPDATE comments
+					SET content = '', deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+					WHERE comment_id = ?`, id); err != nil {
+					return err
+				}
+			}
+			break
+		}
+
+		if _, err := tx.Exec(`DELETE FROM likes WHERE comment_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM comments WHERE comment_id = ?`, id); err != nil {
+			return err
+		}
+
+		// Walk up while the parent is a placeholder that just lost its last reply.
+		if !parentID.Valid {
+			break
+		}
+		var parentDeleted bool
+		if err := tx.QueryRow(
+			`SELECT deleted_at IS NOT NULL FROM comments WHERE comment_id = ?`, parentID.Int64,
+		).Scan(&parentDeleted); err != nil {
+			return err
+		}
+		if !parentDeleted {
+			break
+		}
+		id = int(parentID.Int64)
+	}
+
+	return tx.Commit()
+}
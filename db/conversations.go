@@ -0,0 +1,209 @@
+package db
+
+import (
+	"database/sql"
+
+	"real/models"
+)
+
+// Kinds of conversation.
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// GetConversation returns a conversation with its members.
+func GetConversation(conversationID int) (models.Conversation, error) {
+	var (
+		c       models.Conversation
+		title   sql.NullString
+		ownerID sql.NullInt64
+	)
+	err := DB.QueryRow(
+		`SELECT conversation_id, kind, title, owner_id FROM conversations WHERE conversation_id = ?`,
+		conversationID,
+	).Scan(&c.ConversationID, &c.Kind, &title, &ownerID)
+	if err == sql.ErrNoRows {
+		return c, ErrConversationNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	c.Title = title.String
+	c.OwnerID = int(ownerID.Int64)
+
+	c.Members, err = GetConversationMembers(conversationID)
+	return c, err
+}
+
+// CreateGroupConversation creates a group owned by ownerID with the owner and
+// the given users as members.
+func CreateGroupConversation(ownerID int, title string, memberIDs []int) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO conversations (kind, title, owner_id) VALUES (?, ?, ?)`,
+		ConversationGroup, title, ownerID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, member := range append([]int{ownerID}, memberIDs...) {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`, id, member,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), tx.Commit()
+}
+
+// AddConversationMembers adds users to a conversation and returns the ones
+// who were not members already. New members start with everything sent so
+// far marked read.
+func AddConversationMembers(conversationID int, userIDs []int) ([]int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var added []int
+	for _, userID := range userIDs {
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO conversation_members (conversation_id, user_id, last_read_message_id)
+			VALUES (?, ?, COALESCE((SELECT MAX(message_id) FROM messages WHERE conversation_id = ?), 0))`,
+			conversationID, userID, conversationID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			added = append(added, userID)
+		}
+	}
+
+	return added, tx.Commit()
+}
+
+// RemoveConversationMember removes a user from a conversation. If they owned
+// it, ownership passes to the longest-standing remaining member. It reports
+// whether the user was a member.
+func RemoveConversationMember(conversationID, userID int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?`,
+		conversationID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE conversations SET owner_id = (
+			SELECT user_id FROM conversation_members
+			WHERE conversation_id = ?
+			ORDER BY joined_at, user_id
+			LIMIT 1)
+		WHERE conversation_id = ? AND owner_id = ?`,
+		conversationID, conversationID, userID,
+	); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func RenameConversation(conversationID int, title string) error {
+	_, err := DB.Exec(`UPDATE conversations SET title = ? WHERE conversation_id = ?`, title, conversationID)
+	return err
+}
+
+func CountConversationMembers(conversationID int) (int, error) {
+	var n int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ?`, conversationID).Scan(&n)
+	return n, err
+}
+
+// ListConversations returns every conversation userID belongs to with its
+// latest message and how many messages userID has not read, most recently
+// active first. Messages from users userID blocked are not counted.
+func ListConversations(userID int) ([]models.Conversation, error) {
+	rows, err := DB.Query(`
+		SELECT c.conversation_id, c.kind, c.title, c.owner_id,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.conversation_id = c.conversation_id
+		          AND m.message_id > cm.last_read_message_id
+		          AND m.sender_id != ? AND `+visibleToViewer+`),
+		       (SELECT MAX(m.message_id) FROM messages m
+		        WHERE m.conversation_id = c.conversation_id AND `+visibleToViewer+`) AS last_id
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.conversation_id AND cm.user_id = ?
+		ORDER BY COALESCE(last_id, 0) DESC, c.conversation_id DESC`,
+		userID, userID, userID, userID, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	var lastIDs []sql.NullInt64
+	for rows.Next() {
+		var (
+			c       models.Conversation
+			title   sql.NullString
+			ownerID sql.NullInt64
+			lastID  sql.NullInt64
+		)
+		if err := rows.Scan(&c.ConversationID, &c.Kind, &title, &ownerID, &c.UnreadCount, &lastID); err != nil {
+			return nil, err
+		}
+		c.Title = title.String
+		c.OwnerID = int(ownerID.Int64)
+		conversations = append(conversations, c)
+		lastIDs = append(lastIDs, lastID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range conversations {
+		members, err := GetConversationMembers(conversations[i].ConversationID)
+		if err != nil {
+			return nil, err
+		}
+		conversations[i].Members = members
+
+		if lastIDs[i].Valid {
+			last, err := GetMessage(lastIDs[i].Int64)
+			if err != nil {
+				return nil, err
+			}
+			conversations[i].LastMessage = &last
+		}
+	}
+	return conversations, nil
+}
@@ -6,11 +6,12 @@ import (
 	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	"real/models"
+	"real/utils"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
@@ -27,6 +28,14 @@ func Init(dbPath string) error {
 		return fmt.Errorf("failed to create tables: %v", err)
 	}
 
+	if err = migrate(); err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
+	}
+
+	if err = createSearchIndex(); err != nil {
+		return fmt.Errorf("failed to create search index: %v", err)
+	}
+
 	if err = createCategories(); err != nil {
 		return fmt.Errorf("failed to create categories: %v", err)
 	}
@@ -56,7 +65,17 @@ func createTables() error {
 	return nil
 }
 
+// createCategories seeds a starter set of categories into an empty database.
+// After that, categories are managed by admins through the API.
 func createCategories() error {
+	var count int
+	if err := DB.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&count); err != nil {
+		return fmt.Errorf("error counting categories: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
 	categories := []struct {
 		Name, Description string
 	}{
@@ -68,8 +87,11 @@ func createCategories() error {
 		{"Travel", "Exploring the world, sharing travel experiences"},
 	}
 
-	for _, c := range categories {
-		_, err := DB.Exec(`INSERT OR IGNORE INTO categories (name, description) VALUES (?, ?)`, c.Name, c.Description)
+	for i, c := range categories {
+		_, err := DB.Exec(
+			`INSERT OR IGNORE INTO categories (name, slug, description, sort_order) VALUES (?, ?, ?, ?)`,
+			c.Name, utils.Slugify(c.Name), c.Description, i,
+		)
 		if err != nil {
 			return fmt.Errorf("error inserting category '%s': '%v'", c.Name, err)
 		}
@@ -106,21 +128,21 @@ func GetUser(userID int) ([]string, error) {
 	return []string{username, bio, profilePicture}, nil
 }
 
-func GetAllCategories() ([]models.Category, error) {
-	rows, err := DB.Query("SELECT category_id, name FROM categories")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var categories []models.Category
-	for rows.Next() {
-		var c models.Category
-		if err := rows.Scan(&c.CategoryID, &c.Name); err != nil {
-			return nil, err
+// parseTime parses a timestamp that SQLite returned as text, which happens
+// for computed columns such as MAX(created_at) where the driver cannot see
+// the declared column type.
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
 		}
-		categories = append(categories, c)
 	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
 
-	return categories, nil
+// sqliteTime formats t the way CURRENT_TIMESTAMP does, so the two compare
+// correctly as text.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
 }
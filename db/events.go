@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"real/models"
+)
+
+// ephemeralEventMaxAge is how long ephemeral events are kept. Other
+// processes read them within moments, so this only needs to cover a short
+// stall.
+const ephemeralEventMaxAge = 5 * time.Minute
+
+// CreateEvent stores an event for replay. A userID of 0 means the event went
+// to everyone.
+func CreateEvent(userID int, eventType string, payload []byte) (int64, error) {
+	return insertEvent(userID, eventType, payload, false)
+}
+
+// CreateEphemeralEvent stores an event that other processes should deliver
+// but that is never replayed.
+func CreateEphemeralEvent(userID int, eventType string, payload []byte) (int64, error) {
+	return insertEvent(userID, eventType, payload, true)
+}
+
+func insertEvent(userID int, eventType string, payload []byte, ephemeral bool) (int64, error) {
+	var target sql.NullInt64
+	if userID != 0 {
+		target = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+
+	result, err := DB.Exec(
+		`INSERT INTO events (user_id, type, payload, ephemeral) VALUES (?, ?, ?, ?)`,
+		target, eventType, string(payload), ephemeral,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ListEventsSince returns up to limit events for userID, including events
+// sent to everyone, with an ID greater than after, oldest first.
+func ListEventsSince(userID int, after int64, limit int) ([]models.StoredEvent, error) {
+	rows, err := DB.Query(`
+		SELECT event_id, COALESCE(user_id, 0), type, payload, ephemeral FROM events
+		WHERE event_id > ? AND (user_id = ? OR user_id IS NULL) AND ephemeral = 0
+		ORDER BY event_id
+		LIMIT ?`,
+		after, userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// ListAllEventsSince returns up to limit events of any kind for any user with
+// an ID greater than after, oldest first.
+func ListAllEventsSince(after int64, limit int) ([]models.StoredEvent, error) {
+	rows, err := DB.Query(`
+		SELECT event_id, COALESCE(user_id, 0), type, payload, ephemeral FROM events
+		WHERE event_id > ?
+		ORDER BY event_id
+		LIMIT ?`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]models.StoredEvent, error) {
+	defer rows.Close()
+
+	var events []models.StoredEvent
+	for rows.Next() {
+		var (
+			e       models.StoredEvent
+			payload string
+		)
+		if err := rows.Scan(&e.EventID, &e.UserID, &e.Type, &payload, &e.Ephemeral); err != nil {
+			return nil, err
+		}
+		e.Payload = []byte(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// LatestEventID returns the highest event ID handed out so far, including
+// events that have since been pruned.
+func LatestEventID() (int64, error) {
+	var id int64
+	err := DB.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'events'`).Scan(&id)
+	return id, err
+}
+
+// OldestEventID returns the ID of the oldest event still kept, or 0 when
+// there are none.
+func OldestEventID() (int64, error) {
+	var id int64
+	err := DB.QueryRow(`SELECT COALESCE(MIN(event_id), 0) FROM events`).Scan(&id)
+	return id, err
+}
+
+// PruneEvents deletes events older than maxAge, and ephemeral events once
+// every process has had time to read them.
+func PruneEvents(maxAge time.Duration) error {
+	now := time.Now()
+	_, err := DB.Exec(`DELETE FROM events WHERE created_at < ? OR (ephemeral = 1 AND created_at < ?)`,
+		sqliteTime(now.Add(-maxAge)), sqliteTime(now.Add(-ephemeralEventMaxAge)))
+	return err
+}
+
+// ScheduleEventPruning runs PruneEvents every interval.
+func ScheduleEventPruning(interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := PruneEvents(maxAge); err != nil {
+			log.Printf("error: event pruning failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real/models"
+)
+
+var ErrFilterRuleNotFound = errors.New("filter rule not found")
+
+const filterRuleColumns = `rule_id, kind, pattern, action, note, created_by, created_at, updated_at`
+
+func scanFilterRule(row rowScanner) (models.FilterRule, error) {
+	var r models.FilterRule
+	err := row.Scan(&r.RuleID, &r.Kind, &r.Pattern, &r.Action, &r.Note, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrFilterRuleNotFound
+	}
+	return r, err
+}
+
+// ListFilterRules returns every content filter rule, oldest first, and the
+// revision they are at.
+func ListFilterRules() ([]models.FilterRule, int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	revision, err := filterRevision(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := tx.Query(`SELECT ` + filterRuleColumns + ` FROM filter_rules ORDER BY rule_id`)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	rules := []models.FilterRule{}
+	for rows.Next() {
+		r, err := scanFilterRule(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, revision, rows.Err()
+}
+
+func GetFilterRule(ruleID int) (models.FilterRule, error) {
+	return scanFilterRule(DB.QueryRow(`SELECT `+filterRuleColumns+` FROM filter_rules WHERE rule_id = ?`, ruleID))
+}
+
+// FilterRevision returns a number that goes up every time the filter rules
+// change.
+func FilterRevision() (int64, error) {
+	var revision int64
+	err := DB.QueryRow(`SELECT COALESCE(MAX(revision), 0) FROM filter_revision`).Scan(&revision)
+	return revision, err
+}
+
+func filterRevision(tx *sql.Tx) (int64, error) {
+	var revision int64
+	err := tx.QueryRow(`SELECT COALESCE(MAX(revision), 0) FROM filter_revision`).Scan(&revision)
+	return revision, err
+}
+
+func CreateFilterRule(r *models.FilterRule) error {
+	return changeFilterRules(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`INSERT INTO filter_rules (kind, pattern, action, note, created_by) VALUES (?, ?, ?, ?, ?)`,
+			r.Kind, r.Pattern, r.Action, r.Note, r.CreatedBy,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		r.RuleID = int(id)
+		return err
+	})
+}
+
+func UpdateFilterRule(r models.FilterRule) error {
+	return changeFilterRules(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			UPDATE filter_rules SET kind = ?, pattern = ?, action = ?, note = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE rule_id = ?`,
+			r.Kind, r.Pattern, r.Action, r.Note, r.RuleID,
+		)
+		return ruleChanged(result, err)
+	})
+}
+
+func DeleteFilterRule(ruleID int) error {
+	return changeFilterRules(func(tx *sql.Tx) error {
+		return ruleChanged(tx.Exec(`DELETE FROM filter_rules WHERE rule_id = ?`, ruleID))
+	})
+}
+
+func ruleChanged(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFilterRuleNotFound
+	}
+	return nil
+}
+
+// changeFilterRules runs change and moves the revision on in one
+// transaction.
+func changeFilterRules(change func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := change(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO filter_revision (id, revision) VALUES (1, 1)
+		ON CONFLICT (id) DO UPDATE SET revision = revision + 1`,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HasFingerprint reports whether userID stored content with the given
+// fingerprint within the window. Fingerprints older than the window are
+// forgotten.
+func HasFingerprint(userID int, fingerprint string, window time.Duration) (bool, error) {
+	since := sqliteTime(time.Now().Add(-window))
+	if _, err := DB.Exec(
+		`DELETE FROM content_fingerprints WHERE user_id = ? AND created_at < ?`, userID, since,
+	); err != nil {
+		return false, err
+	}
+
+	var seen bool
+	err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM content_fingerprints WHERE user_id = ? AND fingerprint = ?)`,
+		userID, fingerprint,
+	).Scan(&seen)
+	return seen, err
+}
+
+// RecordFingerprint remembers that userID stored content with the given
+// fingerprint.
+func RecordFingerprint(userID int, fingerprint string) error {
+	_, err := DB.Exec(
+		`INSERT INTO content_fingerprints (user_id, fingerprint) VALUES (?, ?)`, userID, fingerprint,
+	)
+	return err
+}
+
+// UserCreatedAt returns when a user signed up.
+func UserCreatedAt(userID int) (time.Time, error) {
+	var createdAt time.Time
+	err := DB.QueryRow(`SELECT created_at FROM users WHERE user_id = ?`, userID).Scan(&createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return createdAt, ErrUserNotFound
+	}
+	return createdAt, err
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"strings"
+
+	"real/models"
+)
+
+// GetUserIDsByUsername resolves usernames to user IDs. Names that do not
+// belong to anyone are left out of the result.
+func GetUserIDsByUsername(usernames []string) (map[string]int, error) {
+	ids := make(map[string]int)
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+
+	args := make([]interface{}, len(usernames))
+	for i, name := range usernames {
+		args[i] = name
+	}
+
+	rows, err := DB.Query(
+		`SELECT user_id, username FROM users WHERE username IN (`+placeholders(len(usernames))+`)`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   int
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, rows.Err()
+}
+
+// AddMention records that a piece of content mentions a user. It reports
+// false when the mention was already recorded, e.g. when the content is
+// saved again after an edit.
+func AddMention(contentType string, contentID, mentionedUserID, authorID int) (bool, error) {
+	result, err := DB.Exec(`
+		INSERT OR IGNORE INTO mentions (content_type, content_id, mentioned_user_id, author_id)
+		VALUES (?, ?, ?, ?)`,
+		contentType, contentID, mentionedUserID, authorID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetMentions returns the recorded mentions of each of the given pieces of
+// content, keyed by content ID.
+func GetMentions(contentType string, contentIDs []int) (map[int][]models.Mention, error) {
+	mentions := make(map[int][]models.Mention)
+	if len(contentIDs) == 0 {
+		return mentions, nil
+	}
+
+	args := []interface{}{contentType}
+	for _, id := range contentIDs {
+		args = append(args, id)
+	}
+
+	rows, err := DB.Query(`
+		SELECT m.content_id, u.user_id, u.username
+		FROM mentions m JOIN users u ON u.user_id = m.mentioned_user_id
+		WHERE m.content_type = ? AND m.content_id IN (`+placeholders(len(contentIDs))+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			contentID int
+			m         models.Mention
+		)
+		if err := rows.Scan(&contentID, &m.UserID, &m.Username); err != nil {
+			return nil, err
+		}
+		mentions[contentID] = append(mentions[contentID], m)
+	}
+	return mentions, rows.Err()
+}
+
+// placeholders returns "?, ?, ?" with n question marks for an IN (...) list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
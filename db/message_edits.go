@@ -0,0 +1,95 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real/models"
+)
+
+// EditMessage replaces the content of a message that was not deleted and
+// keeps what it said before in its edit history.
+func EditMessage(messageID int64, content string, editedAt time.Time) (models.Message, error) {
+	if err := replaceMessageContent(messageID, `
+		UPDATE messages SET content = ?, edited_at = ?
+		WHERE message_id = ? AND deleted_at IS NULL`,
+		content, sqliteTime(editedAt), messageID,
+	); err != nil {
+		return models.Message{}, err
+	}
+	return GetMessage(messageID)
+}
+
+// DeleteMessage empties a message that was not deleted yet, leaving a
+// tombstone in its place. What it said stays in its edit history.
+func DeleteMessage(messageID int64, deletedAt time.Time) (models.Message, error) {
+	if err := replaceMessageContent(messageID, `
+		UPDATE messages SET content = '', deleted_at = ?
+		WHERE message_id = ? AND deleted_at IS NULL`,
+		sqliteTime(deletedAt), messageID,
+	); err != nil {
+		return models.Message{}, err
+	}
+	return GetMessage(messageID)
+}
+
+// replaceMessageContent saves the current content of a message to its edit
+// history and then runs update, which must only match the message while it
+// is not deleted.
+func replaceMessageContent(messageID int64, update string, args ...interface{}) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var content string
+	err = tx.QueryRow(
+		`SELECT content FROM messages WHERE message_id = ? AND deleted_at IS NULL`, messageID,
+	).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO message_edits (message_id, content) VALUES (?, ?)`, messageID, content,
+	); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(update, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMessageNotFound
+	}
+	return tx.Commit()
+}
+
+// ListMessageEdits returns the earlier versions of a message, oldest first.
+func ListMessageEdits(messageID int64) ([]models.MessageEdit, error) {
+	rows, err := DB.Query(`
+		SELECT edit_id, content, replaced_at FROM message_edits
+		WHERE message_id = ? ORDER BY edit_id`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEdit{}
+	for rows.Next() {
+		var e models.MessageEdit
+		if err := rows.Scan(&e.EditID, &e.Content, &e.ReplacedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, rows.Err()
+}
@@ -0,0 +1,364 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"real/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrDuplicateMessage     = errors.New("message already sent")
+	ErrMessageNotFound      = errors.New("message not found")
+)
+
+func directKey(a, b int) string {
+	return fmt.Sprintf("%d:%d", min(a, b), max(a, b))
+}
+
+// GetOrCreateDirectConversation returns the ID of the one-to-one
+// conversation between two users, creating it on first use.
+func GetOrCreateDirectConversation(userID, otherID int) (int, error) {
+	key := directKey(userID, otherID)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var conversationID int
+	err = tx.QueryRow(`SELECT conversation_id FROM conversations WHERE direct_key = ?`, key).Scan(&conversationID)
+	if err == nil {
+		return conversationID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO conversations (kind, direct_key) VALUES (?, ?)`, ConversationDirect, key)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, member := range []int{userID, otherID} {
+		if _, err := tx.Exec(
+			`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`, id, member,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), tx.Commit()
+}
+
+func IsConversationMember(conversationID, userID int) (bool, error) {
+	var member bool
+	err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ?)`,
+		conversationID, userID,
+	).Scan(&member)
+	return member, err
+}
+
+// CanReadMessage reports whether userID is a member of the conversation the
+// message belongs to.
+func CanReadMessage(messageID int64, userID int) (bool, error) {
+	var member bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM messages m
+			JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+			WHERE m.message_id = ? AND cm.user_id = ?)`,
+		messageID, userID,
+	).Scan(&member)
+	return member, err
+}
+
+func GetConversationMembers(conversationID int) ([]models.ConversationMember, error) {
+	rows, err := DB.Query(`
+		SELECT u.user_id, u.username
+		FROM conversation_members cm JOIN users u ON u.user_id = cm.user_id
+		WHERE cm.conversation_id = ?
+		ORDER BY cm.joined_at, u.user_id`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.ConversationMember{}
+	for rows.Next() {
+		var m models.ConversationMember
+		if err := rows.Scan(&m.UserID, &m.Username); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// Kinds of message.
+const (
+	MessageUser   = "user"
+	MessageSystem = "system"
+)
+
+const messageColumns = `
+	m.message_id, m.conversation_id, m.sender_id, u.username, m.kind,
+	COALESCE(m.client_msg_id, ''), m.content, m.created_at, m.edited_at, m.deleted_at,
+	m.visibility = 'held'`
+
+func scanMessage(row rowScanner) (models.Message, error) {
+	var m models.Message
+	err := row.Scan(&m.MessageID, &m.ConversationID, &m.SenderID, &m.SenderUsername, &m.Kind,
+		&m.ClientMsgID, &m.Content, &m.CreatedAt, &m.EditedAt, &m.DeletedAt, &m.Held)
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, ErrMessageNotFound
+	}
+	return m, err
+}
+
+// CreateMessage stores a message with the given visibility and uploaded
+// attachments, and moves the sender's read marker past it. clientMsgID is
+// optional; reusing one the sender already sent fails with
+// ErrDuplicateMessage.
+func CreateMessage(conversationID, senderID int, kind, content, clientMsgID string, attachmentIDs []int64, visibility string) (models.Message, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return models.Message{}, err
+	}
+	defer tx.Rollback()
+
+	var clientID sql.NullString
+	if clientMsgID != "" {
+		clientID = sql.NullString{String: clientMsgID, Valid: true}
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO messages (conversation_id, sender_id, kind, client_msg_id, content, visibility) VALUES (?, ?, ?, ?, ?, ?)`,
+		conversationID, senderID, kind, clientID, content, visibility,
+	)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return models.Message{}, ErrDuplicateMessage
+	}
+	if err != nil {
+		return models.Message{}, err
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return models.Message{}, err
+	}
+	if err := attachFiles(tx, messageID, senderID, attachmentIDs); err != nil {
+		return models.Message{}, err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE conversation_members SET last_read_message_id = ?
+		WHERE conversation_id = ? AND user_id = ?`,
+		messageID, conversationID, senderID,
+	); err != nil {
+		return models.Message{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, err
+	}
+	return GetMessage(messageID)
+}
+
+func GetMessage(messageID int64) (models.Message, error) {
+	return withAttachments(scanMessage(DB.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m JOIN users u ON u.user_id = m.sender_id
+		WHERE m.message_id = ?`, messageID)))
+}
+
+// GetMessageByClientID returns the message a sender stored under the given
+// client message ID.
+func GetMessageByClientID(senderID int, clientMsgID string) (models.Message, error) {
+	return withAttachments(scanMessage(DB.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m JOIN users u ON u.user_id = m.sender_id
+		WHERE m.sender_id = ? AND m.client_msg_id = ?`, senderID, clientMsgID)))
+}
+
+func withAttachments(m models.Message, err error) (models.Message, error) {
+	if err != nil {
+		return m, err
+	}
+	messages := []models.Message{m}
+	if err := loadAttachments(messages); err != nil {
+		return models.Message{}, err
+	}
+	return messages[0], nil
+}
+
+// visibleToViewer is a condition on messages m that leaves out what users
+// the viewer blocked said, but not system messages about them, and other
+// users' held or hidden messages. Both of its parameters are the viewer's
+// ID.
+const visibleToViewer = `(m.kind = 'system' OR (m.sender_id NOT IN (
+	SELECT blocked_id FROM user_blocks WHERE blocker_id = ?)
+	AND (m.visibility = 'visible' OR m.sender_id = ?)))`
+
+// ListMessages returns up to limit messages of a conversation sent before
+// the given message ID (0 for the latest), oldest first, leaving out those
+// from users viewerID blocked.
+func ListMessages(conversationID, viewerID int, before int64, limit int) ([]models.Message, error) {
+	query := `
+		SELECT ` + messageColumns + `
+		FROM messages m JOIN users u ON u.user_id = m.sender_id
+		WHERE m.conversation_id = ? AND ` + visibleToViewer
+	args := []interface{}{conversationID, viewerID, viewerID}
+	if before > 0 {
+		query += ` AND m.message_id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY m.message_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := loadAttachments(messages); err != nil {
+		return nil, err
+	}
+
+	// Query newest first so LIMIT keeps the latest ones, then flip.
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// MarkConversationRead moves a member's read marker forward to upTo, or to
+// the latest message when upTo is 0. The marker never moves backwards. It
+// returns the marker afterwards and whether it changed.
+func MarkConversationRead(conversationID, userID int, upTo int64) (int64, bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var current, latest int64
+	err = tx.QueryRow(`
+		SELECT cm.last_read_message_id,
+		       COALESCE((SELECT MAX(message_id) FROM messages WHERE conversation_id = cm.conversation_id), 0)
+		FROM conversation_members cm
+		WHERE cm.conversation_id = ? AND cm.user_id = ?`,
+		conversationID, userID,
+	).Scan(&current, &latest)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrConversationNotFound
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	target := latest
+	if upTo > 0 && upTo < latest {
+		target = upTo
+	}
+	if target <= current {
+		return current, false, nil
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE conversation_members SET last_read_message_id = ?
+		WHERE conversation_id = ? AND user_id = ?`,
+		target, conversationID, userID,
+	); err != nil {
+		return 0, false, err
+	}
+	return target, true, tx.Commit()
+}
+
+// PeerReadMarker returns the furthest read marker among the other members
+// of a conversation who share read receipts.
+func PeerReadMarker(conversationID, userID int) (int64, error) {
+	var marker int64
+	err := DB.QueryRow(`
+		SELECT COALESCE(MAX(cm.last_read_message_id), 0)
+		FROM conversation_members cm JOIN users u ON u.user_id = cm.user_id
+		WHERE cm.conversation_id = ? AND cm.user_id != ? AND u.read_receipts = 1`,
+		conversationID, userID,
+	).Scan(&marker)
+	return marker, err
+}
+
+// ListChatUsers returns every other user for the chat sidebar, with the
+// one-to-one conversation they share with userID and how many of its
+// messages userID has not read. Users with the most recent conversation come
+// first, the rest alphabetically.
+func ListChatUsers(userID int) ([]models.ChatUser, error) {
+	rows, err := DB.Query(`
+		SELECT u.user_id, u.username, COALESCE(c.conversation_id, 0),
+		       (SELECT MAX(m.created_at) FROM messages m WHERE m.conversation_id = c.conversation_id),
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.conversation_id = c.conversation_id
+		          AND m.message_id > cm.last_read_message_id
+		          AND m.sender_id != ? AND m.visibility = 'visible'),
+		       COALESCE((SELECT MAX(m.message_id) FROM messages m WHERE m.conversation_id = c.conversation_id), 0) AS last_id
+		FROM users u
+		LEFT JOIN conversations c ON c.direct_key = CASE
+			WHEN u.user_id < ? THEN u.user_id || ':' || ?
+			ELSE ? || ':' || u.user_id
+		END
+		LEFT JOIN conversation_members cm ON cm.conversation_id = c.conversation_id AND cm.user_id = ?
+		WHERE u.user_id != ?
+		ORDER BY last_id DESC, u.username COLLATE NOCASE`,
+		userID, userID, userID, userID, userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.ChatUser{}
+	for rows.Next() {
+		var (
+			u        models.ChatUser
+			lastAt   sql.NullString
+			lastID   int64
+			unreadNS sql.NullInt64
+		)
+		if err := rows.Scan(&u.UserID, &u.Username, &u.ConversationID, &lastAt, &unreadNS, &lastID); err != nil {
+			return nil, err
+		}
+		u.UnreadCount = int(unreadNS.Int64)
+		if lastAt.Valid {
+			t, err := parseTime(lastAt.String)
+			if err != nil {
+				return nil, err
+			}
+			u.LastMessageAt = &t
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
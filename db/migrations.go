@@ -0,0 +1,145 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"real/utils"
+)
+
+// Columns added to tables after they first shipped. CREATE TABLE IF NOT EXISTS
+// in schema.sql never alters an existing table, so databases created from an
+// older schema get these through ALTER TABLE on startup.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"users", "role", "TEXT NOT NULL DEFAULT 'user'"},
+	{"users", "read_receipts", "INTEGER NOT NULL DEFAULT 1"},
+	{"categories", "slug", "TEXT"},
+	{"categories", "sort_order", "INTEGER NOT NULL DEFAULT 0"},
+	{"categories", "archived", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "parent_comment_id", "INTEGER REFERENCES comments(comment_id) ON DELETE CASCADE"},
+	{"comments", "depth", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "deleted_at", "DATETIME"},
+	{"conversations", "kind", "TEXT NOT NULL DEFAULT 'direct'"},
+	{"conversations", "title", "TEXT"},
+	{"conversations", "owner_id", "INTEGER REFERENCES users(user_id) ON DELETE SET NULL"},
+	{"messages", "kind", "TEXT NOT NULL DEFAULT 'user'"},
+	{"messages", "client_msg_id", "TEXT"},
+	{"messages", "edited_at", "DATETIME"},
+	{"messages", "deleted_at", "DATETIME"},
+	{"events", "ephemeral", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "visibility", "TEXT NOT NULL DEFAULT 'visible'"},
+	{"comments", "visibility", "TEXT NOT NULL DEFAULT 'visible'"},
+	{"messages", "visibility", "TEXT NOT NULL DEFAULT 'visible'"},
+}
+
+// Statements that depend on added columns, so they can only run after them.
+var postMigrationStatements = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories(slug)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_thread ON comments(post_id, parent_comment_id)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_id ON messages(sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL`,
+	// Posts used to keep the URL of their image, and now keep its storage
+	// key.
+	`UPDATE posts SET imgurl = 'posts/' || substr(imgurl, length('/images/posts/') + 1) WHERE imgurl LIKE '/images/posts/%'`,
+	// Posts used to have one image, which becomes their first attachment.
+	// It only has the original variant, since no smaller copies were made,
+	// and its size and dimensions were never recorded, so they are 0.
+	`INSERT INTO post_attachments (post_id, position, content_type, created_at)
+		SELECT post_id, 0, CASE WHEN imgurl LIKE '%.png' THEN 'image/png' ELSE 'image/jpeg' END, created_at
+		FROM posts WHERE imgurl IS NOT NULL AND imgurl != ''
+		AND post_id NOT IN (SELECT post_id FROM post_attachments)`,
+	`INSERT INTO post_attachment_variants (attachment_id, variant, storage_key, width, height, size)
+		SELECT a.attachment_id, 'original', p.imgurl, 0, 0, 0
+		FROM post_attachments a JOIN posts p ON p.post_id = a.post_id
+		WHERE p.imgurl IS NOT NULL AND p.imgurl != ''
+		AND a.attachment_id NOT IN (SELECT attachment_id FROM post_attachment_variants)`,
+	`UPDATE posts SET imgurl = NULL WHERE imgurl IS NOT NULL`,
+	// Earlier, they were also listed as medium and thumbnail, which were
+	// the full image all the same. Only these have a size of 0.
+	`DELETE FROM post_attachment_variants WHERE variant != 'original' AND size = 0`,
+}
+
+func migrate() error {
+	for _, c := range addedColumns {
+		if err := addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := backfillCategorySlugs(); err != nil {
+		return err
+	}
+
+	for _, stmt := range postMigrationStatements {
+		if _, err := DB.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to execute %q: %v", stmt, err)
+		}
+	}
+
+	return nil
+}
+
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := DB.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     interface{}
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %v", table, err)
+		}
+		if strings.EqualFold(name, column) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %v", table, err)
+	}
+	rows.Close()
+
+	if _, err := DB.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %v", table, column, err)
+	}
+	return nil
+}
+
+func backfillCategorySlugs() error {
+	rows, err := DB.Query(`SELECT category_id, name FROM categories WHERE slug IS NULL OR slug = ''`)
+	if err != nil {
+		return fmt.Errorf("failed to read categories: %v", err)
+	}
+
+	type pending struct {
+		id   int
+		name string
+	}
+	var missing []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read categories: %v", err)
+		}
+		missing = append(missing, p)
+	}
+	rows.Close()
+
+	for _, p := range missing {
+		slug := utils.Slugify(p.name)
+		if slug == "" {
+			slug = fmt.Sprintf("category-%d", p.id)
+		}
+		if _, err := DB.Exec(`UPDATE categories SET slug = ? WHERE category_id = ?`, slug, p.id); err != nil {
+			return fmt.Errorf("failed to set slug for category %d: %v", p.id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"real/models"
+)
+
+const (
+	CaseOpen     = "open"
+	CaseResolved = "resolved"
+)
+
+var (
+	ErrCaseNotFound = errors.New("case not found")
+	ErrCaseResolved = errors.New("case already resolved")
+)
+
+// CreateReport files a report about a target, adding it to the target's
+// open case or opening a new one. A user who already reported the target
+// in its open case gets that report back, and the returned bool is false.
+func CreateReport(targetType string, targetID int, targetUserID *int, reporterID int, reason, details string) (models.Report, bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return models.Report{}, false, err
+	}
+	defer tx.Rollback()
+
+	caseID, err := openCase(tx, targetType, targetID, targetUserID)
+	if err != nil {
+		return models.Report{}, false, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO reports (case_id, reporter_id, reason, details) VALUES (?, ?, ?, ?)`,
+		caseID, reporterID, reason, details,
+	)
+	if err != nil {
+		return models.Report{}, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return models.Report{}, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Report{}, false, err
+	}
+
+	report, err := scanReport(DB.QueryRow(`
+		SELECT `+reportColumns+`
+		FROM reports r LEFT JOIN users u ON u.user_id = r.reporter_id
+		WHERE r.case_id = ? AND r.reporter_id = ?`, caseID, reporterID))
+	return report, n == 1, err
+}
+
+// FlagContent puts a target the content filter held or hid in front of
+// moderators, as a report without a reporter. details says why.
+func FlagContent(targetType string, targetID, authorID int, details string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	caseID, err := openCase(tx, targetType, targetID, &authorID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO reports (case_id, reason, details) VALUES (?, 'filter', ?)`, caseID, details,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// openCase returns the open case about a target, opening one if there is
+// none.
+func openCase(tx *sql.Tx, targetType string, targetID int, targetUserID *int) (int64, error) {
+	var caseID int64
+	err := tx.QueryRow(`
+		SELECT case_id FROM moderation_cases
+		WHERE target_type = ? AND target_id = ? AND status = 'open'`,
+		targetType, targetID,
+	).Scan(&caseID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return caseID, err
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO moderation_cases (target_type, target_id, target_user_id) VALUES (?, ?, ?)`,
+		targetType, targetID, targetUserID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const reportColumns = `
+	r.report_id, r.case_id, r.reporter_id, COALESCE(u.username, ''), r.reason, r.details, r.created_at`
+
+func scanReport(row rowScanner) (models.Report, error) {
+	var r models.Report
+	err := row.Scan(&r.ReportID, &r.CaseID, &r.ReporterID, &r.ReporterUsername, &r.Reason, &r.Details, &r.CreatedAt)
+	return r, err
+}
+
+const caseColumns = `
+	c.case_id, c.target_type, c.target_id, c.target_user_id, COALESCE(t.username, ''),
+	c.status, COALESCE(c.resolution, ''), c.resolved_by, c.resolved_at, c.created_at,
+	(SELECT COUNT(*) FROM reports r WHERE r.case_id = c.case_id),
+	(SELECT MAX(r.created_at) FROM reports r WHERE r.case_id = c.case_id)`
+
+const caseFrom = `
+	FROM moderation_cases c LEFT JOIN users t ON t.user_id = c.target_user_id`
+
+func scanCase(row rowScanner) (models.ModerationCase, error) {
+	var (
+		c            models.ModerationCase
+		lastReported sql.NullString
+	)
+	err := row.Scan(&c.CaseID, &c.TargetType, &c.TargetID, &c.TargetUserID, &c.TargetUsername,
+		&c.Status, &c.Resolution, &c.ResolvedBy, &c.ResolvedAt, &c.CreatedAt,
+		&c.ReportCount, &lastReported)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrCaseNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	if lastReported.Valid {
+		if c.LastReportedAt, err = parseTime(lastReported.String); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// ListCases returns up to limit cases with the given status created before
+// the given case ID (0 for the latest), newest first.
+func ListCases(status string, before int64, limit int) ([]models.ModerationCase, error) {
+	query := `SELECT ` + caseColumns + caseFrom + ` WHERE c.status = ?`
+	args := []interface{}{status}
+	if before > 0 {
+		query += ` AND c.case_id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY c.case_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := []models.ModerationCase{}
+	for rows.Next() {
+		c, err := scanCase(rows)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+	return cases, rows.Err()
+}
+
+// GetCase returns a case with all of its reports, oldest first.
+func GetCase(caseID int64) (models.ModerationCase, error) {
+	c, err := scanCase(DB.QueryRow(`SELECT `+caseColumns+caseFrom+` WHERE c.case_id = ?`, caseID))
+	if err != nil {
+		return c, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+reportColumns+`
+		FROM reports r LEFT JOIN users u ON u.user_id = r.reporter_id
+		WHERE r.case_id = ? ORDER BY r.report_id`, caseID)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	c.Reports = []models.Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return c, err
+		}
+		c.Reports = append(c.Reports, r)
+	}
+	return c, rows.Err()
+}
+
+// WasReported reports whether anyone ever reported the target.
+func WasReported(targetType string, targetID int) (bool, error) {
+	var reported bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM moderation_cases WHERE target_type = ? AND target_id = ?)`,
+		targetType, targetID,
+	).Scan(&reported)
+	return reported, err
+}
+
+// ResolveCase claims an open case for the action a moderator is taking on
+// it: the case is closed only if it is still open, and the action is
+// recorded in the moderation log, whose ID is returned for ReopenCase. Of
+// moderators acting on a case at once, all but one get ErrCaseResolved.
+func ResolveCase(caseID int64, moderatorID int, resolution, note string) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE moderation_cases SET status = 'resolved', resolution = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+		WHERE case_id = ? AND status = 'open'`, resolution, moderatorID, caseID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if claimed != 1 {
+		var exists bool
+		if err := tx.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM moderation_cases WHERE case_id = ?)`, caseID,
+		).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrCaseNotFound
+		}
+		return 0, ErrCaseResolved
+	}
+
+	result, err = tx.Exec(`
+		INSERT INTO moderation_log (moderator_id, action, target_type, target_id, target_user_id, case_id, note)
+		SELECT ?, ?, target_type, target_id, target_user_id, case_id, ?
+		FROM moderation_cases WHERE case_id = ?`,
+		moderatorID, resolution, note, caseID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	logID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return logID, tx.Commit()
+}
+
+// ReopenCase undoes ResolveCase for an action that could not be carried
+// out, removing its entry from the moderation log.
+func ReopenCase(caseID, logID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE moderation_cases SET status = 'open', resolution = NULL, resolved_by = NULL, resolved_at = NULL
+		WHERE case_id = ?`, caseID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM moderation_log WHERE log_id = ?`, logID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LogAction records in the moderation log an action a moderator took
+// outside of any case.
+func LogAction(moderatorID int, action, targetType string, targetID int, targetUserID *int, note string) error {
+	_, err := DB.Exec(`
+		INSERT INTO moderation_log (moderator_id, action, target_type, target_id, target_user_id, note)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		moderatorID, action, targetType, targetID, targetUserID, note,
+	)
+	return err
+}
+
+// ListModerationLog returns up to limit log entries written before the given
+// entry ID (0 for the latest), newest first.
+func ListModerationLog(before int64, limit int) ([]models.ModerationLogEntry, error) {
+	query := `
+		SELECT l.log_id, l.moderator_id, u.username, l.action, l.target_type, l.target_id,
+			l.target_user_id, l.case_id, l.note, l.created_at
+		FROM moderation_log l JOIN users u ON u.user_id = l.moderator_id`
+	var args []interface{}
+	if before > 0 {
+		query += ` WHERE l.log_id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY l.log_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.ModerationLogEntry{}
+	for rows.Next() {
+		var e models.ModerationLogEntry
+		if err := rows.Scan(&e.LogID, &e.ModeratorID, &e.ModeratorUsername, &e.Action, &e.TargetType,
+			&e.TargetID, &e.TargetUserID, &e.CaseID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"real/models"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+func CreateNotification(n *models.Notification) error {
+	result, err := DB.Exec(
+		`INSERT INTO notifications (user_id, actor_id, kind, target_type, target_id) VALUES (?, ?, ?, ?, ?)`,
+		n.UserID, n.ActorID, n.Kind, n.TargetType, n.TargetID,
+	)
+	if err != nil {
+		return err
+	}
+	n.NotificationID, err = result.LastInsertId()
+	return err
+}
+
+// NotificationExists reports whether the user was already notified about the
+// same action by the same actor on the same target.
+func NotificationExists(userID, actorID int, kind, targetType string, targetID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM notifications
+		WHERE user_id = ? AND actor_id = ? AND kind = ? AND target_type = ? AND target_id = ?)`,
+		userID, actorID, kind, targetType, targetID,
+	).Scan(&exists)
+	return exists, err
+}
+
+const notificationColumns = `
+	n.notification_id, n.user_id, COALESCE(n.actor_id, 0), COALESCE(u.username, ''),
+	n.kind, n.target_type, n.target_id, n.created_at, n.read_at`
+
+func scanNotification(row rowScanner) (models.Notification, error) {
+	var (
+		n      models.Notification
+		readAt sql.NullTime
+	)
+	err := row.Scan(&n.NotificationID, &n.UserID, &n.ActorID, &n.ActorUsername,
+		&n.Kind, &n.TargetType, &n.TargetID, &n.CreatedAt, &readAt)
+	if readAt.Valid {
+		n.ReadAt = &readAt.Time
+	}
+	return n, err
+}
+
+func GetNotification(notificationID int64) (models.Notification, error) {
+	n, err := scanNotification(DB.QueryRow(`
+		SELECT `+notificationColumns+`
+		FROM notifications n LEFT JOIN users u ON u.user_id = n.actor_id
+		WHERE n.notification_id = ?`, notificationID))
+	if err == sql.ErrNoRows {
+		return n, ErrNotificationNotFound
+	}
+	return n, err
+}
+
+// ListNotifications returns a user's notifications newest first. Passing the
+// ID of the last notification of a page as before returns the next page;
+// before = 0 starts from the newest.
+func ListNotifications(userID int, before int64, limit int) ([]models.Notification, error) {
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notifications n LEFT JOIN users u ON u.user_id = n.actor_id
+		WHERE n.user_id = ?`
+	args := []interface{}{userID}
+	if before > 0 {
+		query += ` AND n.notification_id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY n.notification_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+func CountUnreadNotifications(userID int) (int, error) {
+	var n int
+	err := DB.QueryRow(
+		`SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL`, userID,
+	).Scan(&n)
+	return n, err
+}
+
+// MarkNotificationRead marks one of the user's notifications as read.
+// Notifications of other users are reported as not found.
+func MarkNotificationRead(userID int, notificationID int64) error {
+	result, err := DB.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE notification_id = ? AND user_id = ?`,
+		notificationID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+func MarkAllNotificationsRead(userID int) error {
+	_, err := DB.Exec(
+		`UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL`, userID,
+	)
+	return err
+}
@@ -0,0 +1,284 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real/models"
+)
+
+// MaxPostAttachments is how many images a post can carry.
+const MaxPostAttachments = 10
+
+var (
+	ErrPostAttachmentNotFound = errors.New("post attachment not found")
+	ErrTooManyAttachments     = errors.New("post has too many attachments")
+)
+
+const postAttachmentColumns = `
+	a.attachment_id, a.post_id, a.position, a.alt_text, a.content_type, a.created_at,
+	v.variant, v.storage_key, v.width, v.height, v.size`
+
+// scanPostAttachments reads rows of postAttachmentColumns, one per variant
+// and ordered by attachment, into attachments.
+func scanPostAttachments(rows *sql.Rows) ([]models.PostAttachment, error) {
+	var attachments []models.PostAttachment
+	for rows.Next() {
+		var (
+			a       models.PostAttachment
+			name    string
+			variant models.ImageVariant
+		)
+		if err := rows.Scan(&a.AttachmentID, &a.PostID, &a.Position, &a.AltText, &a.ContentType, &a.CreatedAt,
+			&name, &variant.Key, &variant.Width, &variant.Height, &variant.Size); err != nil {
+			return nil, err
+		}
+		variant.URL = imageURL(variant.Key)
+
+		if n := len(attachments); n == 0 || attachments[n-1].AttachmentID != a.AttachmentID {
+			a.Variants = make(map[string]models.ImageVariant)
+			attachments = append(attachments, a)
+		}
+		attachments[len(attachments)-1].Variants[name] = variant
+	}
+	return attachments, rows.Err()
+}
+
+func GetPostAttachment(attachmentID int64) (models.PostAttachment, error) {
+	rows, err := DB.Query(`
+		SELECT `+postAttachmentColumns+`
+		FROM post_attachments a JOIN post_attachment_variants v ON v.attachment_id = a.attachment_id
+		WHERE a.attachment_id = ?`, attachmentID)
+	if err != nil {
+		return models.PostAttachment{}, err
+	}
+	defer rows.Close()
+
+	attachments, err := scanPostAttachments(rows)
+	if err != nil {
+		return models.PostAttachment{}, err
+	}
+	if len(attachments) == 0 {
+		return models.PostAttachment{}, ErrPostAttachmentNotFound
+	}
+	return attachments[0], nil
+}
+
+// AddPostAttachment adds an image after the ones a post already has, as
+// part of tx, and returns its ID. variants maps each variant's name to where
+// it is stored. It fails with ErrTooManyAttachments once the post has
+// MaxPostAttachments.
+func AddPostAttachment(tx *sql.Tx, postID int, altText, contentType string, variants map[string]models.ImageVariant) (int64, error) {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM post_attachments WHERE post_id = ?`, postID).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count >= MaxPostAttachments {
+		return 0, ErrTooManyAttachments
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO post_attachments (post_id, position, alt_text, content_type)
+		VALUES (?, ?, ?, ?)`,
+		postID, count, altText, contentType,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for name, v := range variants {
+		if _, err := tx.Exec(`
+			INSERT INTO post_attachment_variants (attachment_id, variant, storage_key, width, height, size)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			id, name, v.Key, v.Width, v.Height, v.Size,
+		); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// UpdatePostAttachment changes an attachment's alt text, its position, or
+// both; nil leaves either as it is. Moving it shifts the images in between
+// to make room. Positions past the last image move it to the end.
+func UpdatePostAttachment(attachmentID int64, altText *string, position *int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var postID, current int
+	err = tx.QueryRow(`SELECT post_id, position FROM post_attachments WHERE attachment_id = ?`, attachmentID).
+		Scan(&postID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPostAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if altText != nil {
+		if _, err := tx.Exec(`UPDATE post_attachments SET alt_text = ? WHERE attachment_id = ?`, *altText, attachmentID); err != nil {
+			return err
+		}
+	}
+
+	if position != nil {
+		var last int
+		if err := tx.QueryRow(`SELECT MAX(position) FROM post_attachments WHERE post_id = ?`, postID).Scan(&last); err != nil {
+			return err
+		}
+		target := min(max(*position, 0), last)
+		var shift string
+		switch {
+		case target < current:
+			shift = `UPDATE post_attachments SET position = position + 1 WHERE post_id = ? AND position >= ? AND position < ?`
+		case target > current:
+			shift = `UPDATE post_attachments SET position = position - 1 WHERE post_id = ? AND position <= ? AND position > ?`
+		}
+		if shift != "" {
+			if _, err := tx.Exec(shift, postID, target, current); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE post_attachments SET position = ? WHERE attachment_id = ?`, target, attachmentID); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// DeletePostAttachment removes an attachment and closes the gap it leaves
+// in its post. It returns the storage keys of its variants, which the
+// caller removes once nothing refers to them.
+func DeletePostAttachment(attachmentID int64) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var postID, position int
+	err = tx.QueryRow(`SELECT post_id, position FROM post_attachments WHERE attachment_id = ?`, attachmentID).
+		Scan(&postID, &position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPostAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := variantKeys(tx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM post_attachment_variants WHERE attachment_id = ?`,
+		`DELETE FROM post_attachments WHERE attachment_id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, attachmentID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(
+		`UPDATE post_attachments SET position = position - 1 WHERE post_id = ? AND position > ?`,
+		postID, position,
+	); err != nil {
+		return nil, err
+	}
+	return keys, tx.Commit()
+}
+
+func variantKeys(tx *sql.Tx, attachmentID int64) ([]string, error) {
+	rows, err := tx.Query(`SELECT DISTINCT storage_key FROM post_attachment_variants WHERE attachment_id = ?`, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// loadPostAttachments fills in the attachments of each post, and the image
+// URL of the ones that have any.
+func loadPostAttachments(posts []models.Post) error {
+	ids := make([]interface{}, 0, len(posts))
+	for i := range posts {
+		posts[i].Attachments = []models.PostAttachment{}
+		ids = append(ids, posts[i].PostID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+postAttachmentColumns+`
+		FROM post_attachments a JOIN post_attachment_variants v ON v.attachment_id = a.attachment_id
+		WHERE a.post_id IN (`+placeholders(len(ids))+`)
+		ORDER BY a.post_id, a.position, a.attachment_id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	attachments, err := scanPostAttachments(rows)
+	if err != nil {
+		return err
+	}
+
+	byPost := make(map[int][]models.PostAttachment)
+	for _, a := range attachments {
+		byPost[a.PostID] = append(byPost[a.PostID], a)
+	}
+	for i := range posts {
+		if found, ok := byPost[posts[i].PostID]; ok {
+			posts[i].Attachments = found
+			posts[i].ImageURL = found[0].Variants["original"].URL
+		}
+	}
+	return nil
+}
+
+// PostImage is a stored image a post attachment refers to.
+type PostImage struct {
+	AttachmentID int64
+	PostID       int
+	Key          string
+	CreatedAt    time.Time
+}
+
+// ListPostImages returns every stored variant of every post attachment.
+func ListPostImages() ([]PostImage, error) {
+	rows, err := DB.Query(`
+		SELECT a.attachment_id, a.post_id, v.storage_key, a.created_at
+		FROM post_attachments a JOIN post_attachment_variants v ON v.attachment_id = a.attachment_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []PostImage
+	for rows.Next() {
+		var img PostImage
+		if err := rows.Scan(&img.AttachmentID, &img.PostID, &img.Key, &img.CreatedAt); err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
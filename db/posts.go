@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+
+	"real/models"
+	"real/storage"
+)
+
+// GetPostAuthor returns the ID of the user who wrote a post.
+func GetPostAuthor(postID int) (int, error) {
+	var userID int
+	err := DB.QueryRow(`SELECT user_id FROM posts WHERE post_id = ?`, postID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrPostNotFound
+	}
+	return userID, err
+}
+
+const postColumns = `
+	p.post_id, p.user_id, u.username, p.title, p.content,
+	p.created_at, p.updated_at, p.visibility = 'held'`
+
+func scanPost(row rowScanner) (models.Post, error) {
+	var p models.Post
+	err := row.Scan(&p.PostID, &p.UserID, &p.Username, &p.Title, &p.Content,
+		&p.CreatedAt, &p.UpdatedAt, &p.Held)
+	if err == sql.ErrNoRows {
+		return p, ErrPostNotFound
+	}
+	return p, err
+}
+
+// imageURL links to an image posts keep the storage key of. Links may be
+// signed and expire, so they are made fresh on every read.
+func imageURL(key string) string {
+	url, err := storage.Media.URL(key)
+	if err != nil {
+		log.Printf("Error linking post image %s: %v", key, err)
+		return ""
+	}
+	return url
+}
+
+// CanSeePost reports whether a post exists and userID (0 for guests) may see
+// it: everyone can, unless the content filter held or hid it.
+func CanSeePost(postID, userID int) (bool, error) {
+	var visible bool
+	err := DB.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM posts WHERE post_id = ? AND (visibility = 'visible' OR user_id = ?))`,
+		postID, userID,
+	).Scan(&visible)
+	return visible, err
+}
+
+func GetPost(postID int) (models.Post, error) {
+	p, err := scanPost(DB.QueryRow(`
+		SELECT `+postColumns+`
+		FROM posts p JOIN users u ON u.user_id = p.user_id
+		WHERE p.post_id = ?`, postID))
+	if err != nil {
+		return p, err
+	}
+	posts := []models.Post{p}
+	if err := loadPostAttachments(posts); err != nil {
+		return p, err
+	}
+	return posts[0], nil
+}
+
+// DeletePost removes a post along with its comments and everything that
+// refers to either. The files of its attachments are left for
+// uploads.SweepPostImages.
+func DeletePost(postID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM posts WHERE post_id = ?`, postID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPostNotFound
+	}
+
+	const postComments = `SELECT comment_id FROM comments WHERE post_id = ?`
+	for _, stmt := range []string{
+		`DELETE FROM likes WHERE post_id = ? OR comment_id IN (` + postComments + `)`,
+		`DELETE FROM mentions WHERE (content_type = 'post' AND content_id = ?)
+			OR (content_type = 'comment' AND content_id IN (` + postComments + `))`,
+	} {
+		if _, err := tx.Exec(stmt, postID, postID); err != nil {
+			return err
+		}
+	}
+	for _, stmt := range []string{
+		`DELETE FROM comments WHERE post_id = ?`,
+		`DELETE FROM post_categories WHERE post_id = ?`,
+		`DELETE FROM post_attachment_variants WHERE attachment_id IN (
+			SELECT attachment_id FROM post_attachments WHERE post_id = ?)`,
+		`DELETE FROM post_attachments WHERE post_id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, postID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FeedOptions narrows down ListPosts.
+type FeedOptions struct {
+	// ViewerID is the signed-in user, whose blocked and muted users' posts
+	// are left out and whose own held or hidden posts are included. 0 for
+	// guests.
+	ViewerID   int
+	CategoryID int
+	// Before is the post ID to continue from, 0 for the latest posts.
+	Before int
+	Limit  int
+}
+
+// ListPosts returns the newest posts first.
+func ListPosts(opts FeedOptions) ([]models.Post, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p JOIN users u ON u.user_id = p.user_id
+		WHERE (p.visibility = 'visible' OR p.user_id = ?)`
+	args := []interface{}{opts.ViewerID}
+	if opts.ViewerID != 0 {
+		query += `
+		AND p.user_id NOT IN (
+			SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+			UNION SELECT muted_id FROM user_mutes WHERE muter_id = ?)`
+		args = append(args, opts.ViewerID, opts.ViewerID)
+	}
+	if opts.CategoryID != 0 {
+		query += ` AND p.post_id IN (SELECT post_id FROM post_categories WHERE category_id = ?)`
+		args = append(args, opts.CategoryID)
+	}
+	if opts.Before > 0 {
+		query += ` AND p.post_id < ?`
+		args = append(args, opts.Before)
+	}
+	query += ` ORDER BY p.post_id DESC LIMIT ?`
+	args = append(args, opts.Limit)
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts, loadPostAttachments(posts)
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"time"
+)
+
+// AddPresence changes how many connections userID has open on a process by
+// delta, and returns how many the user has across all processes that sent
+// a heartbeat since staleBefore.
+func AddPresence(instanceID string, userID, delta int, staleBefore time.Time) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO presence (instance_id, user_id, connections) VALUES (?, ?, ?)
+		ON CONFLICT(instance_id, user_id) DO UPDATE SET connections = connections + excluded.connections`,
+		instanceID, userID, delta,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM presence WHERE connections <= 0`); err != nil {
+		return 0, err
+	}
+
+	var total int
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(p.connections), 0)
+		FROM presence p JOIN instances i ON i.instance_id = p.instance_id
+		WHERE p.user_id = ? AND i.heartbeat_at >= ?`,
+		userID, sqliteTime(staleBefore),
+	).Scan(&total); err != nil {
+		return 0, err
+	}
+
+	return total, tx.Commit()
+}
+
+// IsPresent reports whether userID has a connection open on any process that
+// sent a heartbeat since staleBefore.
+func IsPresent(userID int, staleBefore time.Time) (bool, error) {
+	var present bool
+	err := DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM presence p JOIN instances i ON i.instance_id = p.instance_id
+			WHERE p.user_id = ? AND i.heartbeat_at >= ?)`,
+		userID, sqliteTime(staleBefore),
+	).Scan(&present)
+	return present, err
+}
+
+// ListPresentUserIDs returns, in ascending order, every user with a
+// connection open on a process that sent a heartbeat since staleBefore.
+func ListPresentUserIDs(staleBefore time.Time) ([]int, error) {
+	rows, err := DB.Query(`
+		SELECT DISTINCT p.user_id
+		FROM presence p JOIN instances i ON i.instance_id = p.instance_id
+		WHERE i.heartbeat_at >= ?
+		ORDER BY p.user_id`,
+		sqliteTime(staleBefore),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RemoveStaleInstances forgets processes that have not sent a heartbeat
+// since staleBefore, along with their connections, and returns the users who
+// had connections on them.
+func RemoveStaleInstances(staleBefore time.Time) ([]int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cutoff := sqliteTime(staleBefore)
+	rows, err := tx.Query(`
+		SELECT DISTINCT user_id FROM presence
+		WHERE instance_id IN (SELECT instance_id FROM instances WHERE heartbeat_at < ?)`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM presence
+		WHERE instance_id IN (SELECT instance_id FROM instances WHERE heartbeat_at < ?)`, cutoff); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM instances WHERE heartbeat_at < ?`, cutoff); err != nil {
+		return nil, err
+	}
+
+	return userIDs, tx.Commit()
+}
+
+// SyncInstance records a heartbeat from a process and replaces its
+// connection counts with counts, repairing them if another process removed
+// this one as stale while it was stalled.
+func SyncInstance(instanceID string, counts map[int]int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO instances (instance_id, heartbeat_at) VALUES (?, ?)
+		ON CONFLICT(instance_id) DO UPDATE SET heartbeat_at = excluded.heartbeat_at`,
+		instanceID, sqliteTime(time.Now()),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM presence WHERE instance_id = ?`, instanceID); err != nil {
+		return err
+	}
+	for userID, n := range counts {
+		if _, err := tx.Exec(
+			`INSERT INTO presence (instance_id, user_id, connections) VALUES (?, ?, ?)`,
+			instanceID, userID, n,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real/models"
+)
+
+const (
+	SanctionSuspension = "suspension"
+	SanctionBan        = "ban"
+)
+
+var (
+	ErrSanctionNotFound = errors.New("sanction not found")
+	ErrSanctionInactive = errors.New("sanction no longer in force")
+)
+
+// CreateSanction suspends a user until expiresAt, or bans them when
+// expiresAt is nil, and signs them out everywhere.
+func CreateSanction(userID, issuedBy int, kind, reason string, expiresAt *time.Time) (models.Sanction, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return models.Sanction{}, err
+	}
+	defer tx.Rollback()
+
+	var expires interface{}
+	if expiresAt != nil {
+		expires = sqliteTime(*expiresAt)
+	}
+	result, err := tx.Exec(
+		`INSERT INTO user_sanctions (user_id, kind, reason, issued_by, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, kind, reason, issuedBy, expires,
+	)
+	if err != nil {
+		return models.Sanction{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return models.Sanction{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return models.Sanction{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Sanction{}, err
+	}
+
+	return GetSanction(id)
+}
+
+const sanctionColumns = `
+	sanction_id, user_id, kind, reason, issued_by, expires_at, lifted_by, lifted_at, created_at`
+
+// activeSanction is the condition for a sanction that is in force.
+const activeSanction = `lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`
+
+func scanSanction(row rowScanner) (models.Sanction, error) {
+	var s models.Sanction
+	err := row.Scan(&s.SanctionID, &s.UserID, &s.Kind, &s.Reason, &s.IssuedBy,
+		&s.ExpiresAt, &s.LiftedBy, &s.LiftedAt, &s.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, ErrSanctionNotFound
+	}
+	return s, err
+}
+
+func GetSanction(sanctionID int64) (models.Sanction, error) {
+	return scanSanction(DB.QueryRow(`SELECT `+sanctionColumns+` FROM user_sanctions WHERE sanction_id = ?`, sanctionID))
+}
+
+// ActiveSanction returns the sanction in force on a user that lasts the
+// longest, a ban before any suspension, or nil when there is none.
+func ActiveSanction(userID int) (*models.Sanction, error) {
+	s, err := scanSanction(DB.QueryRow(`
+		SELECT `+sanctionColumns+` FROM user_sanctions
+		WHERE user_id = ? AND `+activeSanction+`
+		ORDER BY expires_at IS NULL DESC, expires_at DESC LIMIT 1`,
+		userID, sqliteTime(time.Now()),
+	))
+	if errors.Is(err, ErrSanctionNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSanctions returns every sanction a user has been given, newest first.
+func ListSanctions(userID int) ([]models.Sanction, error) {
+	rows, err := DB.Query(`
+		SELECT `+sanctionColumns+` FROM user_sanctions
+		WHERE user_id = ? ORDER BY sanction_id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sanctions := []models.Sanction{}
+	for rows.Next() {
+		s, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, s)
+	}
+	return sanctions, rows.Err()
+}
+
+// LiftSanction ends a sanction that is still in force.
+func LiftSanction(sanctionID int64, moderatorID int) (models.Sanction, error) {
+	if _, err := GetSanction(sanctionID); err != nil {
+		return models.Sanction{}, err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE user_sanctions SET lifted_by = ?, lifted_at = CURRENT_TIMESTAMP
+		WHERE sanction_id = ? AND `+activeSanction,
+		moderatorID, sanctionID, sqliteTime(time.Now()),
+	)
+	if err != nil {
+		return models.Sanction{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return models.Sanction{}, err
+	} else if n == 0 {
+		return models.Sanction{}, ErrSanctionInactive
+	}
+	return GetSanction(sanctionID)
+}
@@ -11,6 +11,8 @@ CREATE TABLE IF NOT EXISTS users (
     gender TEXT,
     first_name TEXT,
     last_name TEXT,
+    role TEXT NOT NULL DEFAULT 'user', -- 'user', 'moderator' or 'admin'
+    read_receipts INTEGER NOT NULL DEFAULT 1, -- whether others see when this user has read their messages
 
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
@@ -32,7 +34,10 @@ CREATE TABLE IF NOT EXISTS post_categories (
 CREATE TABLE IF NOT EXISTS categories (
 	category_id INTEGER PRIMARY KEY AUTOINCREMENT,
 	name TEXT NOT NULL UNIQUE,
+	slug TEXT,
 	description TEXT,
+	sort_order INTEGER NOT NULL DEFAULT 0,
+	archived INTEGER NOT NULL DEFAULT 0,
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
@@ -42,21 +47,58 @@ CREATE TABLE IF NOT EXISTS posts (
 	user_id INTEGER NOT NULL,
 	title TEXT NOT NULL,
 	content TEXT NOT NULL,
-	imgurl TEXT,
+	imgurl TEXT, -- no longer written; images are in post_attachments
+	-- 'held' until a moderator reviews it, or 'hidden' by the content
+	-- filter; either way only its author sees it.
+	visibility TEXT NOT NULL DEFAULT 'visible',
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 );
 
+-- Images attached to posts, shown in position order starting from 0.
+CREATE TABLE IF NOT EXISTS post_attachments (
+	attachment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	post_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	alt_text TEXT NOT NULL DEFAULT '',
+	content_type TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_attachments_post ON post_attachments(post_id, position);
+
+-- The sizes each post attachment is stored in: 'original', and the smaller
+-- 'medium' and 'thumbnail' copies, which share the original's storage_key
+-- when it is no larger.
+CREATE TABLE IF NOT EXISTS post_attachment_variants (
+	attachment_id INTEGER NOT NULL,
+	variant TEXT NOT NULL,
+	storage_key TEXT NOT NULL,
+	width INTEGER NOT NULL, -- 0 when not known
+	height INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	PRIMARY KEY (attachment_id, variant),
+	FOREIGN KEY (attachment_id) REFERENCES post_attachments(attachment_id) ON DELETE CASCADE
+);
+
 CREATE TABLE IF NOT EXISTS comments (
 	comment_id INTEGER PRIMARY KEY AUTOINCREMENT,
 	post_id INTEGER NOT NULL,
 	user_id INTEGER NOT NULL,
+	parent_comment_id INTEGER, -- NULL for top-level comments
+	depth INTEGER NOT NULL DEFAULT 0,
 	content TEXT NOT NULL,
+	deleted_at DATETIME, -- set when a comment with replies is deleted
+	-- 'held' until a moderator reviews it, or 'hidden' by the content
+	-- filter; either way only its author sees it.
+	visibility TEXT NOT NULL DEFAULT 'visible',
 	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 	FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
-	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (parent_comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE
 );
 
 CREATE TABLE IF NOT EXISTS likes (
@@ -84,3 +126,286 @@ CREATE TABLE IF NOT EXISTS sessions (
 
 
 
+CREATE TABLE IF NOT EXISTS user_blocks (
+	blocker_id INTEGER NOT NULL,
+	blocked_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (blocker_id, blocked_id),
+	FOREIGN KEY (blocker_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (blocked_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked ON user_blocks(blocked_id);
+
+-- Muting only hides the muted user's posts from the muter's feed.
+CREATE TABLE IF NOT EXISTS user_mutes (
+	muter_id INTEGER NOT NULL,
+	muted_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (muter_id, muted_id),
+	FOREIGN KEY (muter_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (muted_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_mutes_muted ON user_mutes(muted_id);
+
+-- One row per user mentioned in a piece of content. The unique key is what
+-- keeps edits from notifying the same user twice.
+CREATE TABLE IF NOT EXISTS mentions (
+	mention_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	content_type TEXT NOT NULL CHECK (content_type IN ('post', 'comment', 'message')),
+	content_id INTEGER NOT NULL,
+	mentioned_user_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (content_type, content_id, mentioned_user_id),
+	FOREIGN KEY (mentioned_user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (author_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	notification_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	actor_id INTEGER,
+	kind TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	read_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (actor_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id, notification_id);
+
+-- A user has at most one vote per post and per comment.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_likes_user_post ON likes(user_id, post_id) WHERE post_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_likes_user_comment ON likes(user_id, comment_id) WHERE comment_id IS NOT NULL;
+
+-- Private messaging. A one-to-one conversation is identified by direct_key,
+-- "<lower user id>:<higher user id>", so each pair of users has exactly one.
+-- Group conversations have no direct_key but a title and an owner who
+-- manages the members.
+CREATE TABLE IF NOT EXISTS conversations (
+	conversation_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL DEFAULT 'direct' CHECK(kind IN ('direct', 'group')),
+	direct_key TEXT UNIQUE,
+	title TEXT,
+	owner_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (owner_id) REFERENCES users(user_id) ON DELETE SET NULL
+);
+
+-- last_read_message_id is the member's read marker: every message up to and
+-- including it has been seen.
+CREATE TABLE IF NOT EXISTS conversation_members (
+	conversation_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	last_read_message_id INTEGER NOT NULL DEFAULT 0,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (conversation_id, user_id),
+	FOREIGN KEY (conversation_id) REFERENCES conversations(conversation_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	message_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	conversation_id INTEGER NOT NULL,
+	sender_id INTEGER NOT NULL,
+	-- 'system' messages record membership changes such as "alice added bob";
+	-- their sender is the member who made the change.
+	kind TEXT NOT NULL DEFAULT 'user' CHECK(kind IN ('user', 'system')),
+	-- Idempotency key chosen by the sender's client, so a resend after a
+	-- dropped connection does not store the message twice.
+	client_msg_id TEXT,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	edited_at DATETIME,
+	-- A deleted message stays as a tombstone with empty content.
+	deleted_at DATETIME,
+	-- 'held' until a moderator reviews it, or 'hidden' by the content
+	-- filter; either way only its author sees it.
+	visibility TEXT NOT NULL DEFAULT 'visible',
+	FOREIGN KEY (conversation_id) REFERENCES conversations(conversation_id) ON DELETE CASCADE,
+	FOREIGN KEY (sender_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, message_id);
+
+-- Files attached to messages. message_id stays NULL from the upload until
+-- the message is sent; until then only the uploader can see the file.
+CREATE TABLE IF NOT EXISTS message_attachments (
+	attachment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	uploader_id INTEGER NOT NULL,
+	message_id INTEGER,
+	filename TEXT NOT NULL,
+	stored_name TEXT NOT NULL UNIQUE,
+	content_type TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (uploader_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (message_id) REFERENCES messages(message_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_attachments_message ON message_attachments(message_id);
+
+-- Resumable uploads. The data received so far is kept on local disk until
+-- the upload is used for a post image or message attachment, or expires.
+CREATE TABLE IF NOT EXISTS uploads (
+	upload_id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	purpose TEXT NOT NULL, -- 'post_image' or 'attachment'
+	filename TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	received INTEGER NOT NULL DEFAULT 0,
+	-- The message attachment made from it, so sending can be retried.
+	attachment_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_user ON uploads(user_id);
+CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads(expires_at);
+
+-- What a message said before each edit or its deletion, for moderators.
+CREATE TABLE IF NOT EXISTS message_edits (
+	edit_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	replaced_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (message_id) REFERENCES messages(message_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(message_id, edit_id);
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user ON conversation_members(user_id);
+
+-- Real-time events kept for replay, so a client that reconnects with the ID
+-- of the last event it saw gets everything it missed. user_id is NULL for
+-- events sent to everyone. Ephemeral events, such as presence changes, are
+-- only here so other forum processes can pick them up; they are never
+-- replayed.
+CREATE TABLE IF NOT EXISTS events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	ephemeral INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_user ON events(user_id, event_id);
+CREATE INDEX IF NOT EXISTS idx_events_created ON events(created_at);
+
+-- Forum processes sharing this database, and the open real-time
+-- connections each one has per user. A process that stops sending
+-- heartbeats is considered gone along with its connections.
+CREATE TABLE IF NOT EXISTS instances (
+	instance_id TEXT PRIMARY KEY,
+	heartbeat_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS presence (
+	instance_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	connections INTEGER NOT NULL,
+	PRIMARY KEY (instance_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_presence_user ON presence(user_id);
+
+-- Reports about the same target are grouped into one case while it is
+-- open. A report after the case was resolved opens a new one.
+CREATE TABLE IF NOT EXISTS moderation_cases (
+	case_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'message', 'user')),
+	target_id INTEGER NOT NULL,
+	target_user_id INTEGER, -- who wrote the content, or the reported user
+	status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved')),
+	resolution TEXT, -- the action that resolved the case
+	resolved_by INTEGER,
+	resolved_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (target_user_id) REFERENCES users(user_id) ON DELETE SET NULL,
+	FOREIGN KEY (resolved_by) REFERENCES users(user_id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_moderation_cases_open ON moderation_cases(target_type, target_id) WHERE status = 'open';
+CREATE INDEX IF NOT EXISTS idx_moderation_cases_status ON moderation_cases(status, case_id);
+
+CREATE TABLE IF NOT EXISTS reports (
+	report_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	case_id INTEGER NOT NULL,
+	reporter_id INTEGER, -- NULL when the content filter flagged it
+	reason TEXT NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (case_id, reporter_id),
+	FOREIGN KEY (case_id) REFERENCES moderation_cases(case_id) ON DELETE CASCADE,
+	FOREIGN KEY (reporter_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Every action a moderator takes, newest last.
+CREATE TABLE IF NOT EXISTS moderation_log (
+	log_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	moderator_id INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id INTEGER NOT NULL,
+	target_user_id INTEGER,
+	case_id INTEGER,
+	note TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (moderator_id) REFERENCES users(user_id),
+	FOREIGN KEY (case_id) REFERENCES moderation_cases(case_id)
+);
+
+-- A sanctioned user cannot sign in until expires_at, or ever for a ban,
+-- which has no expiry, unless a moderator lifts it.
+CREATE TABLE IF NOT EXISTS user_sanctions (
+	sanction_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	kind TEXT NOT NULL CHECK (kind IN ('suspension', 'ban')),
+	reason TEXT NOT NULL,
+	issued_by INTEGER NOT NULL,
+	expires_at DATETIME,
+	lifted_by INTEGER,
+	lifted_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (issued_by) REFERENCES users(user_id),
+	FOREIGN KEY (lifted_by) REFERENCES users(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sanctions_user ON user_sanctions(user_id, expires_at);
+
+-- Words and patterns the content filter acts on. Admins edit them at run
+-- time; filter_revision goes up with every change so each process knows to
+-- reload them.
+CREATE TABLE IF NOT EXISTS filter_rules (
+	rule_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL CHECK (kind IN ('word', 'regex')),
+	pattern TEXT NOT NULL,
+	action TEXT NOT NULL CHECK (action IN ('reject', 'hold', 'shadow')),
+	note TEXT NOT NULL DEFAULT '',
+	created_by INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (created_by) REFERENCES users(user_id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS filter_revision (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	revision INTEGER NOT NULL
+);
+
+-- Hashes of what users recently submitted, to catch the same text posted
+-- over and over.
+CREATE TABLE IF NOT EXISTS content_fingerprints (
+	user_id INTEGER NOT NULL,
+	fingerprint TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_content_fingerprints_user ON content_fingerprints(user_id, fingerprint, created_at);
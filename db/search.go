@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"real/models"
+)
+
+// ErrEmptySearchQuery is returned when a search query has no searchable terms
+// left once punctuation and FTS5 operators have been stripped out.
+var ErrEmptySearchQuery = errors.New("search query has no searchable terms")
+
+// Markers passed to snippet(). They cannot appear in user text that survives
+// html.EscapeString, so they can be swapped for <mark> tags safely.
+const (
+	snippetOpen  = "\x02"
+	snippetClose = "\x03"
+)
+
+func createSearchIndex() error {
+	var fts5 bool
+	if err := DB.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return fmt.Errorf("failed to check FTS5 support: %v", err)
+	}
+	if !fts5 {
+		return fmt.Errorf("SQLite was built without FTS5; build with -tags sqlite_fts5")
+	}
+
+	var existing int
+	if err := DB.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('posts_fts', 'comments_fts')`,
+	).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to inspect search index: %v", err)
+	}
+
+	sqlFile, err := os.Open("db/search.sql")
+	if err != nil {
+		return fmt.Errorf("failed to open search schema file: %v", err)
+	}
+	defer sqlFile.Close()
+
+	sqlBytes, err := io.ReadAll(sqlFile)
+	if err != nil {
+		return fmt.Errorf("failed to read search schema file: %v", err)
+	}
+
+	if _, err := DB.Exec(string(sqlBytes)); err != nil {
+		return fmt.Errorf("failed to execute search schema: %v", err)
+	}
+
+	// The triggers only see rows written from now on, so a freshly created
+	// index is backfilled from whatever is already in the database.
+	if existing < 2 {
+		if _, err := DB.Exec(`INSERT INTO posts_fts (posts_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to backfill posts index: %v", err)
+		}
+		if _, err := DB.Exec(`INSERT INTO comments_fts (comments_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to backfill comments index: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SearchOptions narrows a full-text search. Zero values mean "no filter".
+type SearchOptions struct {
+	CategoryID int
+	Kind       string // "post", "comment" or "" for both
+	Limit      int
+	Offset     int
+}
+
+// Search runs a full-text query over posts and comments and returns the
+// matches ordered by bm25 relevance, best first.
+func Search(query string, opts SearchOptions) ([]models.SearchResult, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return nil, ErrEmptySearchQuery
+	}
+
+	var (
+		parts []string
+		args  []interface{}
+	)
+
+	if opts.Kind == "" || opts.Kind == "post" {
+		q := `
+			SELECT 'post', p.post_id, p.post_id, p.title,
+			       snippet(posts_fts, -1, ?, ?, '…', 16),
+			       bm25(posts_fts, 10.0, 1.0),
+			       u.username, p.created_at
+			FROM posts_fts
+			JOIN posts p ON p.post_id = posts_fts.rowid
+			JOIN users u ON u.user_id = p.user_id
+			WHERE posts_fts MATCH ? AND p.visibility = 'visible'`
+		args = append(args, snippetOpen, snippetClose, match)
+		if opts.CategoryID != 0 {
+			q += ` AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = p.post_id AND pc.category_id = ?)`
+			args = append(args, opts.CategoryID)
+		}
+		parts = append(parts, q)
+	}
+
+	if opts.Kind == "" || opts.Kind == "comment" {
+		q := `
+			SELECT 'comment', c.comment_id, c.post_id, p.title,
+			       snippet(comments_fts, 0, ?, ?, '…', 16),
+			       bm25(comments_fts),
+			       u.username, c.created_at
+			FROM comments_fts
+			JOIN comments c ON c.comment_id = comments_fts.rowid
+			JOIN posts p ON p.post_id = c.post_id
+			JOIN users u ON u.user_id = c.user_id
+			WHERE comments_fts MATCH ? AND c.visibility = 'visible' AND p.visibility = 'visible'`
+		args = append(args, snippetOpen, snippetClose, match)
+		if opts.CategoryID != 0 {
+			q += ` AND EXISTS (SELECT 1 FROM post_categories pc WHERE pc.post_id = c.post_id AND pc.category_id = ?)`
+			args = append(args, opts.CategoryID)
+		}
+		parts = append(parts, q)
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("unknown search kind %q", opts.Kind)
+	}
+
+	sqlQuery := strings.Join(parts, " UNION ALL ") + ` ORDER BY 6 LIMIT ? OFFSET ?`
+	args = append(args, opts.Limit, opts.Offset)
+
+	rows, err := DB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var (
+			r       models.SearchResult
+			snippet sql.NullString
+		)
+		if err := rows.Scan(&r.Kind, &r.ID, &r.PostID, &r.Title, &snippet, &r.Rank, &r.Author, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Snippet = highlightSnippet(snippet.String)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// buildMatchQuery turns free-form user input into a safe FTS5 expression.
+// Double-quoted sections become phrase queries, a trailing * on a bare word
+// becomes a prefix query, and everything else is reduced to plain terms that
+// must all match. FTS5 operators typed by the user are never passed through.
+func buildMatchQuery(input string) string {
+	var terms []string
+
+	for i, section := range strings.Split(input, `"`) {
+		words := strings.FieldsFunc(section, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '*'
+		})
+
+		// Odd sections were enclosed in quotes.
+		if i%2 == 1 {
+			var phrase []string
+			for _, w := range words {
+				if w = strings.Trim(w, "*"); w != "" {
+					phrase = append(phrase, w)
+				}
+			}
+			if len(phrase) > 0 {
+				terms = append(terms, `"`+strings.Join(phrase, " ")+`"`)
+			}
+			continue
+		}
+
+		for _, w := range words {
+			prefix := strings.HasSuffix(w, "*")
+			if w = strings.Trim(w, "*"); w == "" {
+				continue
+			}
+			term := `"` + w + `"`
+			if prefix {
+				term += "*"
+			}
+			terms = append(terms, term)
+		}
+	}
+
+	return strings.Join(terms, " ")
+}
+
+func highlightSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, snippetOpen, "<mark>")
+	return strings.ReplaceAll(s, snippetClose, "</mark>")
+}
@@ -0,0 +1,44 @@
+-- Full-text indexes over posts and comments. Both are external-content
+-- FTS5 tables: the text lives in posts/comments and the triggers below keep
+-- the index in sync with every insert, update and delete.
+
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+	title,
+	content,
+	content='posts',
+	content_rowid='post_id',
+	tokenize='porter unicode61'
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+	content,
+	content='comments',
+	content_rowid='comment_id',
+	tokenize='porter unicode61'
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+	INSERT INTO posts_fts (rowid, title, content) VALUES (new.post_id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+	INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.post_id, old.title, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+	INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', old.post_id, old.title, old.content);
+	INSERT INTO posts_fts (rowid, title, content) VALUES (new.post_id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_insert AFTER INSERT ON comments BEGIN
+	INSERT INTO comments_fts (rowid, content) VALUES (new.comment_id, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_delete AFTER DELETE ON comments BEGIN
+	INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.comment_id, old.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS comments_fts_update AFTER UPDATE OF content ON comments BEGIN
+	INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', old.comment_id, old.content);
+	INSERT INTO comments_fts (rowid, content) VALUES (new.comment_id, new.content);
+END;
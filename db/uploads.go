@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"real/models"
+)
+
+var ErrUploadNotFound = errors.New("upload not found")
+
+const uploadColumns = `
+	upload_id, user_id, purpose, filename, size, received, attachment_id, created_at, expires_at`
+
+func scanUpload(row rowScanner) (models.Upload, error) {
+	var u models.Upload
+	err := row.Scan(&u.UploadID, &u.UserID, &u.Purpose, &u.Filename, &u.Size, &u.Received,
+		&u.AttachmentID, &u.CreatedAt, &u.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, ErrUploadNotFound
+	}
+	return u, err
+}
+
+func CreateUpload(u models.Upload) error {
+	_, err := DB.Exec(`
+		INSERT INTO uploads (upload_id, user_id, purpose, filename, size, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		u.UploadID, u.UserID, u.Purpose, u.Filename, u.Size, sqliteTime(u.ExpiresAt),
+	)
+	return err
+}
+
+func GetUpload(uploadID string) (models.Upload, error) {
+	return scanUpload(DB.QueryRow(`SELECT `+uploadColumns+` FROM uploads WHERE upload_id = ?`, uploadID))
+}
+
+// CountUploads returns how many uploads a user has that have not expired.
+func CountUploads(userID int, now time.Time) (int, error) {
+	var n int
+	err := DB.QueryRow(
+		`SELECT COUNT(*) FROM uploads WHERE user_id = ? AND expires_at > ?`, userID, sqliteTime(now),
+	).Scan(&n)
+	return n, err
+}
+
+// AdvanceUpload records that an upload received data up to received and
+// pushes its expiry to expiresAt.
+func AdvanceUpload(uploadID string, received int64, expiresAt time.Time) error {
+	_, err := DB.Exec(
+		`UPDATE uploads SET received = ?, expires_at = ? WHERE upload_id = ?`,
+		received, sqliteTime(expiresAt), uploadID,
+	)
+	return err
+}
+
+// SetUploadAttachment records the message attachment an upload became.
+func SetUploadAttachment(uploadID string, attachmentID int64) error {
+	_, err := DB.Exec(`UPDATE uploads SET attachment_id = ? WHERE upload_id = ?`, attachmentID, uploadID)
+	return err
+}
+
+func DeleteUpload(uploadID string) error {
+	_, err := DB.Exec(`DELETE FROM uploads WHERE upload_id = ?`, uploadID)
+	return err
+}
+
+// ListExpiredUploads returns the IDs of the uploads that expired by now.
+func ListExpiredUploads(now time.Time) ([]string, error) {
+	rows, err := DB.Query(`SELECT upload_id FROM uploads WHERE expires_at <= ?`, sqliteTime(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"real/models"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+func UserExists(userID int) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE user_id = ?)`, userID).Scan(&exists)
+	return exists, err
+}
+
+func GetUserSettings(userID int) (models.UserSettings, error) {
+	var s models.UserSettings
+	err := DB.QueryRow(`SELECT read_receipts FROM users WHERE user_id = ?`, userID).Scan(&s.ReadReceipts)
+	if err == sql.ErrNoRows {
+		return s, ErrUserNotFound
+	}
+	return s, err
+}
+
+func UpdateUserSettings(userID int, s models.UserSettings) error {
+	_, err := DB.Exec(
+		`UPDATE users SET read_receipts = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		s.ReadReceipts, userID,
+	)
+	return err
+}
+
+// GetUsernames maps each of the given user IDs that exists to its username.
+func GetUsernames(userIDs []int) (map[int]string, error) {
+	names := make(map[int]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return names, nil
+	}
+
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+	rows, err := DB.Query(`SELECT user_id, username FROM users WHERE user_id IN (`+placeholders(len(userIDs))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   int
+			name string
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	return names, rows.Err()
+}
+
+// ClearProfile removes what a user wrote about themselves and their picture.
+func ClearProfile(userID int) error {
+	_, err := DB.Exec(
+		`UPDATE users SET bio = NULL, profile_picture = NULL, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?`,
+		userID,
+	)
+	return err
+}
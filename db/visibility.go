@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Who can see a post, comment or message. Held and hidden content is only
+// shown to its author; held content is marked as waiting for review, hidden
+// content looks to its author like any other.
+const (
+	Visible = "visible"
+	Held    = "held"
+	Hidden  = "hidden"
+)
+
+var ErrContentNotFound = errors.New("content not found")
+
+// visibilityTables maps the content types that have a visibility to their
+// table and ID column.
+var visibilityTables = map[string][2]string{
+	"post":    {"posts", "post_id"},
+	"comment": {"comments", "comment_id"},
+	"message": {"messages", "message_id"},
+}
+
+// Publish makes held or hidden content visible to everyone. It reports
+// whether the content was not visible before.
+func Publish(contentType string, contentID int) (bool, error) {
+	t, ok := visibilityTables[contentType]
+	if !ok {
+		return false, nil
+	}
+
+	result, err := DB.Exec(
+		`UPDATE `+t[0]+` SET visibility = 'visible' WHERE `+t[1]+` = ? AND visibility != 'visible'`, contentID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// GetVisibility returns the visibility of a post, comment or message.
+func GetVisibility(contentType string, contentID int) (string, error) {
+	t, ok := visibilityTables[contentType]
+	if !ok {
+		return "", fmt.Errorf("unknown content type %q", contentType)
+	}
+
+	var visibility string
+	err := DB.QueryRow(`SELECT visibility FROM `+t[0]+` WHERE `+t[1]+` = ?`, contentID).Scan(&visibility)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrContentNotFound
+	}
+	return visibility, err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"real/models"
+)
+
+// Vote types, matching the like_type column of likes.
+const (
+	VoteLike    = "like"
+	VoteDislike = "dislike"
+)
+
+// CastVote records a like or dislike by userID on a post or comment.
+// Casting the same vote twice takes it back. It returns the vote totals after
+// the change and the user's vote before it ("" if there was none).
+func CastVote(userID int, targetType string, targetID int, voteType string) (models.VoteSummary, string, error) {
+	var summary models.VoteSummary
+
+	column, err := voteColumn(targetType)
+	if err != nil {
+		return summary, "", err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return summary, "", err
+	}
+	defer tx.Rollback()
+
+	var (
+		likeID   int
+		previous string
+	)
+	err = tx.QueryRow(
+		`SELECT like_id, like_type FROM likes WHERE user_id = ? AND `+column+` = ?`, userID, targetID,
+	).Scan(&likeID, &previous)
+
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec(
+			`INSERT INTO likes (user_id, `+column+`, like_type) VALUES (?, ?, ?)`, userID, targetID, voteType,
+		)
+		summary.MyVote = voteType
+	case err != nil:
+		return summary, "", err
+	case previous == voteType:
+		_, err = tx.Exec(`DELETE FROM likes WHERE like_id = ?`, likeID)
+	default:
+		_, err = tx.Exec(`UPDATE likes SET like_type = ?, created_at = CURRENT_TIMESTAMP WHERE like_id = ?`, voteType, likeID)
+		summary.MyVote = voteType
+	}
+	if err != nil {
+		return summary, "", err
+	}
+
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(like_type = 'like'), 0), COALESCE(SUM(like_type = 'dislike'), 0)
+		FROM likes WHERE `+column+` = ?`, targetID,
+	).Scan(&summary.Likes, &summary.Dislikes); err != nil {
+		return summary, "", err
+	}
+
+	return summary, previous, tx.Commit()
+}
+
+func voteColumn(targetType string) (string, error) {
+	switch targetType {
+	case "post":
+		return "post_id", nil
+	case "comment":
+		return "comment_id", nil
+	}
+	return "", fmt.Errorf("cannot vote on %q", targetType)
+}
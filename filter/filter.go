@@ -0,0 +1,198 @@
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"real/auth"
+	"real/config"
+	"real/db"
+	"real/models"
+)
+
+// Rule kinds.
+const (
+	KindWord  = "word"
+	KindRegex = "regex"
+)
+
+// What happens to content the filter catches, weakest first. Allow is what
+// Check returns for content it lets through.
+const (
+	Allow  = ""
+	Shadow = "shadow"
+	Hold   = "hold"
+	Reject = "reject"
+)
+
+var strength = map[string]int{Allow: 0, Shadow: 1, Hold: 2, Reject: 3}
+
+// ValidAction reports whether action is one a rule can take.
+func ValidAction(action string) bool {
+	return action == Shadow || action == Hold || action == Reject
+}
+
+// Verdict is what the filter decided about a piece of content.
+type Verdict struct {
+	Action string
+	// Reason says which check caught the content, for moderators. It is
+	// never shown to the author.
+	Reason string
+
+	// fingerprint is what Remember records for the duplicate check.
+	fingerprint string
+}
+
+// Visibility is the visibility content with this verdict is stored with. It
+// must not be called for rejected content.
+func (v Verdict) Visibility() string {
+	switch v.Action {
+	case Hold:
+		return db.Held
+	case Shadow:
+		return db.Hidden
+	}
+	return db.Visible
+}
+
+func (v *Verdict) raise(action, reason string) {
+	if strength[action] > strength[v.Action] {
+		v.Action, v.Reason = action, reason
+	}
+}
+
+// Compile turns a rule's pattern into the regexp it matches with. Words match
+// whole words in any case; regexes are used as written.
+func Compile(kind, pattern string) (*regexp.Regexp, error) {
+	switch kind {
+	case KindWord:
+		return regexp.Compile(`(?i)\b` + regexp.QuoteMeta(pattern) + `\b`)
+	case KindRegex:
+		return regexp.Compile(pattern)
+	}
+	return nil, fmt.Errorf("unknown rule kind %q", kind)
+}
+
+type compiledRule struct {
+	models.FilterRule
+	re *regexp.Regexp
+}
+
+// rules caches the compiled rules along with the revision they were loaded
+// at. Every Check compares that revision with the database's, so edits made
+// through the admin API, or by another process, apply without a restart.
+var rules struct {
+	sync.Mutex
+	revision int64
+	loaded   bool
+	list     []compiledRule
+}
+
+func currentRules() ([]compiledRule, error) {
+	revision, err := db.FilterRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	rules.Lock()
+	defer rules.Unlock()
+	if rules.loaded && rules.revision == revision {
+		return rules.list, nil
+	}
+
+	list, revision, err := db.ListFilterRules()
+	if err != nil {
+		return nil, err
+	}
+	compiled := make([]compiledRule, 0, len(list))
+	for _, r := range list {
+		re, err := Compile(r.Kind, r.Pattern)
+		if err != nil {
+			// Rules are validated when saved, so this only happens if the
+			// table was edited by hand. Skip the rule rather than let
+			// nobody post.
+			continue
+		}
+		compiled = append(compiled, compiledRule{r, re})
+	}
+	rules.list, rules.revision, rules.loaded = compiled, revision, true
+	return compiled, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Check runs text written by userID through the filter rules, the link
+// limit for new accounts and the duplicate check. When several apply, the
+// strongest action wins. Moderators and admins are not filtered.
+//
+// Check only looks the text up for the duplicate check; call Remember once
+// the content is stored, so a submission that fails for another reason can
+// be sent again.
+func Check(userID int, text string) (Verdict, error) {
+	var v Verdict
+	if auth.HasRole(strconv.Itoa(userID), auth.RoleModerator) {
+		return v, nil
+	}
+
+	list, err := currentRules()
+	if err != nil {
+		return v, err
+	}
+	for _, r := range list {
+		if r.re.MatchString(text) {
+			v.raise(r.Action, fmt.Sprintf("matched rule %d (%s %q)", r.RuleID, r.Kind, r.Pattern))
+		}
+	}
+
+	cfg := config.Current.Filter
+	if links := len(linkPattern.FindAllStringIndex(text, -1)); links > cfg.NewAccountMaxLinks {
+		createdAt, err := db.UserCreatedAt(userID)
+		if err != nil {
+			return v, err
+		}
+		if time.Since(createdAt) < time.Duration(cfg.NewAccountHours)*time.Hour {
+			v.raise(cfg.LinkAction, fmt.Sprintf("%d links from a new account", links))
+		}
+	}
+
+	if normalized := normalize(text); cfg.DuplicateWindowMinutes > 0 && len(normalized) >= cfg.DuplicateMinLength {
+		sum := sha256.Sum256([]byte(normalized))
+		window := time.Duration(cfg.DuplicateWindowMinutes) * time.Minute
+		v.fingerprint = hex.EncodeToString(sum[:])
+		duplicate, err := db.HasFingerprint(userID, v.fingerprint, window)
+		if err != nil {
+			return v, err
+		}
+		if duplicate {
+			v.raise(cfg.DuplicateAction, "duplicate of recent content")
+		}
+	}
+	return v, nil
+}
+
+// Remember records the text v was reached on for the duplicate check. Call
+// it once the content is stored.
+func Remember(userID int, v Verdict) error {
+	if v.fingerprint == "" {
+		return nil
+	}
+	return db.RecordFingerprint(userID, v.fingerprint)
+}
+
+// normalize lowercases text and collapses whitespace, so resubmitting the
+// same text with different spacing still counts as a duplicate.
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Flag opens a moderation case about content that was stored held or hidden
+// because of v, so a moderator can publish or remove it.
+func Flag(contentType string, contentID, authorID int, v Verdict) error {
+	return db.FlagContent(contentType, contentID, authorID, "filter: "+v.Reason)
+}
@@ -1,6 +1,6 @@
 module real
 
-go 1.23
+go 1.23.0
 
 require (
 	github.com/google/uuid v1.6.0
@@ -8,3 +8,7 @@ require (
 )
 
 require github.com/mattn/go-sqlite3 v1.14.28
+
+require github.com/gorilla/websocket v1.5.3
+
+require golang.org/x/image v0.25.0
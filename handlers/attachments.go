@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/api"
+	"real/auth"
+	"real/chat"
+	"real/uploads"
+)
+
+// UploadAttachmentHandler serves POST /api/attachments, a multipart form
+// with the file in "file". The returned attachment_id is then passed in
+// attachment_ids when sending the message.
+func UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Leave room for the multipart headers around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, uploads.Attachments.MaxSize+1<<20)
+	file, header, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeUploadError(w, uploads.ErrTooLarge)
+		return
+	}
+	if err != nil {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"file": "Choose a file to upload"})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := chat.Upload(userID, header.Filename, file)
+	if errors.Is(err, uploads.ErrTooLarge) || errors.Is(err, uploads.ErrUnsupportedType) {
+		writeUploadError(w, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error uploading attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusCreated, attachment)
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	message := "This type of file cannot be attached"
+	if errors.Is(err, uploads.ErrTooLarge) {
+		message = "Attachments must be at most " + strconv.FormatInt(uploads.Attachments.MaxSize>>20, 10) + " MB"
+	}
+	api.FailFields(w, http.StatusBadRequest, map[string]string{"file": message})
+}
+
+// ServeAttachmentHandler serves GET /api/attachments/{id} to the members of
+// the conversation the attachment was sent in. Attachments link to their
+// /media/ URL instead; this stays for clients that kept the old links.
+func ServeAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	attachmentID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	attachment, file, err := chat.OpenAttachment(userID, attachmentID)
+	if errors.Is(err, chat.ErrAttachmentNotFound) {
+		api.Fail(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	defer file.Close()
+
+	serveAttachment(w, r, attachment, file)
+}
@@ -0,0 +1,72 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"real/chat"
+	"real/db"
+)
+
+// TestHeldMessageAttachment fetches the attachment of a held message as
+// each kind of user. Only the sender and moderators get it until the
+// message is approved.
+func TestHeldMessageAttachment(t *testing.T) {
+	senderID, sender := newSession(t)
+	recipientID, recipient := newSession(t)
+	moderatorID, moderator := newSession(t)
+	if _, err := db.DB.Exec(`UPDATE users SET role = 'moderator' WHERE user_id = ?`, moderatorID); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := chat.OpenDirect(senderID, recipientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachment, err := chat.Upload(senderID, "note.txt", strings.NewReader("a note"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, _, err := chat.Send(senderID, conversation.ConversationID, "see attached", "", []int64{attachment.AttachmentID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setVisibility := func(visibility string) {
+		t.Helper()
+		if _, err := db.DB.Exec(`UPDATE messages SET visibility = ? WHERE message_id = ?`, visibility, msg.MessageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	attachmentID := strconv.FormatInt(attachment.AttachmentID, 10)
+	fetch := func(session *http.Cookie) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/attachments/"+attachmentID, nil)
+		r.SetPathValue("id", attachmentID)
+		return serve(ServeAttachmentHandler, r, session).Code
+	}
+
+	setVisibility(db.Held)
+	for _, c := range []struct {
+		name    string
+		session *http.Cookie
+		want    int
+	}{
+		{"sender", sender, http.StatusOK},
+		{"recipient", recipient, http.StatusNotFound},
+		{"moderator", moderator, http.StatusOK},
+	} {
+		if got := fetch(c.session); got != c.want {
+			t.Errorf("held message, %s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+
+	setVisibility(db.Visible)
+	if got := fetch(recipient); got != http.StatusOK {
+		t.Errorf("approved message, recipient: got %d, want %d", got, http.StatusOK)
+	}
+}
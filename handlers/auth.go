@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"real/api"
 	"real/db"
 
 	"github.com/google/uuid"
@@ -16,11 +17,11 @@ import (
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/logout" {
-		
+		api.Fail(w, http.StatusNotFound, "Not found")
 		return
 	}
 	if r.Method != http.MethodPost {
-		
+		api.Fail(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -37,14 +38,8 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	// Set content type first
-	w.Header().Set("Content-Type", "application/json")
-
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Method not allowed",
-		})
+		api.Fail(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -55,23 +50,20 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&loginData); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Invalid request body",
-		})
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Validate inputs
-	if loginData.Identifier == "" || loginData.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"errors": map[string]string{
-				"identifier": "Required",
-				"password":   "Required",
-			},
-		})
+	required := make(map[string]string)
+	if loginData.Identifier == "" {
+		required["identifier"] = "Required"
+	}
+	if loginData.Password == "" {
+		required["password"] = "Required"
+	}
+	if len(required) > 0 {
+		api.FailFields(w, http.StatusBadRequest, required)
 		return
 	}
 
@@ -91,28 +83,36 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	).Scan(&userID, &username, &email, &passwordHash)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": false,
-				"message": "Invalid credentials",
-			})
+			api.Fail(w, http.StatusUnauthorized, "Invalid credentials")
 		} else {
 			log.Printf("Database error: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"message": "Internal server error",
-			})
+			api.WriteError(w, api.Internal())
 		}
 		return
 	}
 
 	// Compare password hashes
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(loginData.Password)); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid credentials",
-		})
+		api.Fail(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	// Suspended users cannot sign in until the suspension ends, banned users at all
+	sanction, err := db.ActiveSanction(userID)
+	if err != nil {
+		log.Printf("Error checking sanctions: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if sanction != nil {
+		apiErr := api.NewError(http.StatusForbidden, "Account banned").WithCode(api.CodeAccountBanned).
+			WithDetails(map[string]string{"reason": sanction.Reason})
+		if sanction.ExpiresAt != nil {
+			apiErr.Code = api.CodeAccountSuspended
+			apiErr.Message = "Account suspended until " + sanction.ExpiresAt.UTC().Format("2 January 2006 15:04 UTC")
+			apiErr.Details["expires_at"] = sanction.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		api.WriteError(w, apiErr)
 		return
 	}
 
@@ -123,10 +123,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	// Delete any existing sessions
 	if _, err := db.DB.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
 		log.Printf("Error deleting existing sessions: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Internal server error",
-		})
+		api.WriteError(w, api.Internal())
 		return
 	}
 
@@ -136,10 +133,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		sessionID, userID, expiresAt,
 	); err != nil {
 		log.Printf("Error creating session: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Internal server error",
-		})
+		api.WriteError(w, api.Internal())
 		return
 	}
 
@@ -155,7 +149,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Successful login response
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"success":       true,
 		"authenticated": true,
 		"user": map[string]string{
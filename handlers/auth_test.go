@@ -0,0 +1,40 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"real/api"
+)
+
+// TestLoginRequiredFields leaves out the identifier, the password or both,
+// and expects an error for each field left out and no other.
+func TestLoginRequiredFields(t *testing.T) {
+	for _, c := range []struct {
+		body string
+		want map[string]string
+	}{
+		{`{"password": "secret"}`, map[string]string{"identifier": "Required"}},
+		{`{"identifier": "someone"}`, map[string]string{"password": "Required"}},
+		{`{}`, map[string]string{"identifier": "Required", "password": "Required"}},
+	} {
+		w := httptest.NewRecorder()
+		LoginHandler(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(c.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", c.body, w.Code, http.StatusBadRequest)
+			continue
+		}
+		var envelope struct {
+			Error api.Error `json:"error"`
+		}
+		decode(t, w, &envelope)
+		if !reflect.DeepEqual(envelope.Error.Details, c.want) {
+			t.Errorf("%s: details %v, want %v", c.body, envelope.Error.Details, c.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+	"real/models"
+)
+
+// ListBlocksHandler serves GET /api/me/blocks.
+func ListBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	listBlockedUsers(w, r, db.ListBlocks, "blocks")
+}
+
+// ListMutesHandler serves GET /api/me/mutes.
+func ListMutesHandler(w http.ResponseWriter, r *http.Request) {
+	listBlockedUsers(w, r, db.ListMutes, "mutes")
+}
+
+// BlockUserHandler serves POST /api/users/{id}/block. A blocked user cannot
+// message you, see whether you are online, mention you or reply to your
+// posts and comments, and their posts leave your feed.
+func BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeBlockedUser(w, r, db.Block, "block", "blocking user")
+}
+
+// UnblockUserHandler serves DELETE /api/users/{id}/block.
+func UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeBlockedUser(w, r, db.Unblock, "block", "unblocking user")
+}
+
+// MuteUserHandler serves POST /api/users/{id}/mute. Muting only hides the
+// user's posts from your feed.
+func MuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeBlockedUser(w, r, db.Mute, "mute", "muting user")
+}
+
+// UnmuteUserHandler serves DELETE /api/users/{id}/mute.
+func UnmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeBlockedUser(w, r, db.Unmute, "mute", "unmuting user")
+}
+
+func listBlockedUsers(w http.ResponseWriter, r *http.Request, list func(int) ([]models.BlockedUser, error), key string) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	users, err := list(userID)
+	if err != nil {
+		log.Printf("Error listing %s: %v", key, err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{key: users})
+}
+
+func changeBlockedUser(w http.ResponseWriter, r *http.Request, change func(userID, otherID int) error, verb, action string) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	otherID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if otherID == userID {
+		api.Fail(w, http.StatusBadRequest, "You cannot "+verb+" yourself")
+		return
+	}
+
+	exists, err := db.UserExists(otherID)
+	if err != nil {
+		log.Printf("Error %s: %v", action, err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if !exists {
+		api.Fail(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := change(userID, otherID); err != nil {
+		log.Printf("Error %s: %v", action, err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/db"
+	"real/models"
+	"real/utils"
+)
+
+const maxCategoryNameLength = 50
+
+// categoryInput is the body accepted by the admin category endpoints. Fields
+// left out of a PATCH keep their current value.
+type categoryInput struct {
+	Name        *string `json:"name"`
+	Slug        *string `json:"slug"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sort_order"`
+	Archived    *bool   `json:"archived"`
+}
+
+// GetCategoriesHandler serves GET /api/categories: every category that can
+// still receive posts, with post counts and last activity.
+func GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := db.ListCategories(false)
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+		api.Fail(w, http.StatusInternalServerError, "Failed to fetch categories")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, categories)
+}
+
+// AdminListCategoriesHandler serves GET /api/admin/categories, which also
+// includes archived categories.
+func AdminListCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	categories, err := db.ListCategories(true)
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+		api.Fail(w, http.StatusInternalServerError, "Failed to fetch categories")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, categories)
+}
+
+// CreateCategoryHandler serves POST /api/admin/categories.
+func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	var input categoryInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var category models.Category
+	applyCategoryInput(&category, input)
+
+	if errs := validateCategory(category); len(errs) > 0 {
+		api.FailFields(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if !writeCategoryConflicts(w, category) {
+		return
+	}
+
+	if err := db.CreateCategory(&category); err != nil {
+		log.Printf("Error creating category: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusCreated, category)
+}
+
+// UpdateCategoryHandler serves PATCH /api/admin/categories/{id}. Archiving is
+// done here by sending {"archived": true}.
+func UpdateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Category not found")
+		return
+	}
+
+	category, err := db.GetCategory(id)
+	if errors.Is(err, db.ErrCategoryNotFound) {
+		api.Fail(w, http.StatusNotFound, "Category not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching category: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	var input categoryInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	applyCategoryInput(&category, input)
+
+	if errs := validateCategory(category); len(errs) > 0 {
+		api.FailFields(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if !writeCategoryConflicts(w, category) {
+		return
+	}
+
+	if err := db.UpdateCategory(category); err != nil {
+		log.Printf("Error updating category: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, category)
+}
+
+// DeleteCategoryHandler serves DELETE /api/admin/categories/{id}.
+func DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Category not found")
+		return
+	}
+
+	err = db.DeleteCategory(id)
+	switch {
+	case errors.Is(err, db.ErrCategoryNotFound):
+		api.Fail(w, http.StatusNotFound, "Category not found")
+	case errors.Is(err, db.ErrCategoryInUse):
+		api.Fail(w, http.StatusConflict, "Category has posts; archive it instead")
+	case err != nil:
+		log.Printf("Error deleting category: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	}
+}
+
+func applyCategoryInput(c *models.Category, input categoryInput) {
+	if input.Name != nil {
+		c.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Slug != nil {
+		c.Slug = strings.TrimSpace(*input.Slug)
+	}
+	if input.Description != nil {
+		c.Description = strings.TrimSpace(*input.Description)
+	}
+	if input.SortOrder != nil {
+		c.SortOrder = *input.SortOrder
+	}
+	if input.Archived != nil {
+		c.Archived = *input.Archived
+	}
+	if c.Slug == "" {
+		c.Slug = utils.Slugify(c.Name)
+	}
+}
+
+func validateCategory(c models.Category) map[string]string {
+	errors := make(map[string]string)
+
+	if c.Name == "" {
+		errors["name"] = "Name is required"
+	} else if len(c.Name) > maxCategoryNameLength {
+		errors["name"] = "Name must be at most 50 characters"
+	}
+
+	if c.Slug == "" {
+		errors["slug"] = "Slug is required"
+	} else if !utils.ValidSlug(c.Slug) {
+		errors["slug"] = "Slug may only contain lowercase letters, digits and single hyphens"
+	}
+
+	return errors
+}
+
+// writeCategoryConflicts answers 409 with field errors and returns false when
+// the name or slug of c is already taken by another category.
+func writeCategoryConflicts(w http.ResponseWriter, c models.Category) bool {
+	nameTaken, slugTaken, err := db.CategoryConflicts(c.Name, c.Slug, c.CategoryID)
+	if err != nil {
+		log.Printf("Error checking category conflicts: %v", err)
+		api.WriteError(w, api.Internal())
+		return false
+	}
+	if !nameTaken && !slugTaken {
+		return true
+	}
+
+	errs := make(map[string]string)
+	if nameTaken {
+		errs["name"] = "Name already in use"
+	}
+	if slugTaken {
+		errs["slug"] = "Slug already in use"
+	}
+	api.FailFields(w, http.StatusConflict, errs)
+	return false
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/auth"
+	"real/config"
+	"real/db"
+	"real/filter"
+	"real/mentions"
+	"real/models"
+	"real/notifications"
+)
+
+const maxCommentThreadsPerPage = 100
+
+// CreateCommentHandler serves POST /api/posts/{id}/comments. The body is
+// {"content": "...", "parent_comment_id": 12}; leave parent_comment_id out
+// for a top-level comment.
+func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	var input struct {
+		Content  string `json:"content"`
+		ParentID *int   `json:"parent_comment_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	content := strings.TrimSpace(input.Content)
+	if content == "" {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"content": "Comment cannot be empty"})
+		return
+	}
+
+	verdict, err := filter.Check(userID, content)
+	if err != nil {
+		log.Printf("Error filtering comment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if verdict.Action == filter.Reject {
+		api.WriteError(w, api.NewError(http.StatusBadRequest, "Your comment was rejected by the content filter").
+			WithCode(api.CodeRejected))
+		return
+	}
+
+	comment, err := db.CreateComment(postID, userID, input.ParentID, content, config.Current.Comments.MaxDepth, verdict.Visibility())
+	switch {
+	case errors.Is(err, db.ErrPostNotFound):
+		api.Fail(w, http.StatusNotFound, "Post not found")
+	case errors.Is(err, db.ErrCommentNotFound):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"parent_comment_id": "Comment not found on this post"})
+	case errors.Is(err, db.ErrParentDeleted):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"parent_comment_id": "Cannot reply to a deleted comment"})
+	case errors.Is(err, db.ErrReplyBlocked):
+		api.Fail(w, http.StatusForbidden, "You cannot reply to this user")
+	case errors.Is(err, db.ErrMaxDepth):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"parent_comment_id": "Replies cannot be nested any deeper"})
+	case err != nil:
+		log.Printf("Error creating comment: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		if err := filter.Remember(userID, verdict); err != nil {
+			log.Printf("Error remembering comment: %v", err)
+		}
+		// Held or hidden comments notify nobody until a moderator publishes
+		// them.
+		if verdict.Action != filter.Allow {
+			if err := filter.Flag(mentions.ContentComment, comment.CommentID, userID, verdict); err != nil {
+				log.Printf("Error flagging comment: %v", err)
+			}
+		} else {
+			if err := mentions.Record(mentions.ContentComment, comment.CommentID, userID, comment.Content); err != nil {
+				log.Printf("Error recording mentions: %v", err)
+			}
+			if err := notifyReply(comment); err != nil {
+				log.Printf("Error sending reply notification: %v", err)
+			}
+		}
+		if err := renderComments([]*models.Comment{comment}); err != nil {
+			log.Printf("Error rendering comment: %v", err)
+		}
+		api.WriteJSON(w, http.StatusCreated, comment)
+	}
+}
+
+// ListCommentsHandler serves GET /api/posts/{id}/comments. Pagination is by
+// top-level comment: limit and offset count threads, and every page carries
+// the full set of replies for its threads. format=flat returns the comments
+// as one list in thread order instead of a nested tree.
+func ListCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	params := r.URL.Query()
+
+	format := params.Get("format")
+	if format == "" {
+		format = "tree"
+	}
+	if format != "tree" && format != "flat" {
+		api.Fail(w, http.StatusBadRequest, "Format must be tree or flat")
+		return
+	}
+
+	limit := config.Current.Comments.PageSize
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(limit, maxCommentThreadsPerPage)
+	}
+
+	offset := 0
+	if v := params.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+
+	viewerID, _ := auth.CurrentUserID(r)
+	visible, err := db.CanSeePost(postID, viewerID)
+	if err != nil {
+		log.Printf("Error fetching post: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if !visible {
+		api.Fail(w, http.StatusNotFound, "Post not found")
+		return
+	}
+
+	total, err := db.CountCommentThreads(postID, viewerID)
+	if err != nil {
+		log.Printf("Error counting comments: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	comments, err := db.ListCommentThreads(postID, viewerID, limit, offset)
+	if err != nil {
+		log.Printf("Error fetching comments: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	if err := renderComments(comments); err != nil {
+		log.Printf("Error rendering comments: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	if format == "tree" {
+		comments = buildCommentTree(comments)
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"comments":      comments,
+		"format":        format,
+		"limit":         limit,
+		"offset":        offset,
+		"total_threads": total,
+	})
+}
+
+// DeleteCommentHandler serves DELETE /api/comments/{id}.
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	commentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Comment not found")
+		return
+	}
+
+	comment, err := db.GetComment(commentID)
+	if errors.Is(err, db.ErrCommentNotFound) || (err == nil && comment.Deleted) {
+		api.Fail(w, http.StatusNotFound, "Comment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching comment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if comment.UserID != userID {
+		api.Fail(w, http.StatusForbidden, "You can only delete your own comments")
+		return
+	}
+
+	if err := db.DeleteComment(commentID); err != nil && !errors.Is(err, db.ErrCommentNotFound) {
+		log.Printf("Error deleting comment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// notifyReply tells the author of the parent comment, or of the post for a
+// top-level comment, that someone replied to them.
+func notifyReply(c *models.Comment) error {
+	var (
+		recipientID int
+		err         error
+	)
+	if c.ParentID != nil {
+		var parent *models.Comment
+		parent, err = db.GetComment(*c.ParentID)
+		if err == nil {
+			recipientID = parent.UserID
+		}
+	} else {
+		recipientID, err = db.GetPostAuthor(c.PostID)
+	}
+	if err != nil {
+		return err
+	}
+	if recipientID == 0 {
+		return nil
+	}
+	return notifications.Notify(recipientID, c.UserID, notifications.KindReply, "comment", c.CommentID)
+}
+
+// renderComments fills in ContentHTML, linking the mentions recorded for
+// each comment.
+func renderComments(comments []*models.Comment) error {
+	ids := make([]int, 0, len(comments))
+	for _, c := range comments {
+		if !c.Deleted {
+			ids = append(ids, c.CommentID)
+		}
+	}
+
+	recorded, err := db.GetMentions(mentions.ContentComment, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range comments {
+		c.ContentHTML = mentions.Render(c.Content, recorded[c.CommentID])
+	}
+	return nil
+}
+
+// buildCommentTree nests a thread-ordered list under its top-level comments.
+func buildCommentTree(flat []*models.Comment) []*models.Comment {
+	byID := make(map[int]*models.Comment, len(flat))
+	roots := []*models.Comment{}
+
+	for _, c := range flat {
+		byID[c.CommentID] = c
+		if c.ParentID == nil {
+			roots = append(roots, c)
+			continue
+		}
+		if parent, ok := byID[*c.ParentID]; ok {
+			parent.Replies = append(parent.Replies, c)
+		}
+	}
+	return roots
+}
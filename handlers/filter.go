@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+	"real/filter"
+	"real/models"
+)
+
+const (
+	maxFilterPatternLength = 500
+	maxFilterNoteLength    = 500
+)
+
+// filterRuleInput is the body accepted by the admin filter rule endpoints.
+// Fields left out of a PATCH keep their current value.
+type filterRuleInput struct {
+	Kind    *string `json:"kind"`
+	Pattern *string `json:"pattern"`
+	Action  *string `json:"action"`
+	Note    *string `json:"note"`
+}
+
+// ListFilterRulesHandler serves GET /api/admin/filter-rules.
+func ListFilterRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, revision, err := db.ListFilterRules()
+	if err != nil {
+		log.Printf("Error fetching filter rules: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"rules":    rules,
+		"revision": revision,
+	})
+}
+
+// CreateFilterRuleHandler serves POST /api/admin/filter-rules with a body of
+// {"kind": "word", "pattern": "...", "action": "hold", "note": "..."}. The
+// rule applies to the next post, comment or message.
+func CreateFilterRuleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input filterRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rule := models.FilterRule{CreatedBy: &userID}
+	applyFilterRuleInput(&rule, input)
+
+	if errs := validateFilterRule(rule); len(errs) > 0 {
+		api.FailFields(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if err := db.CreateFilterRule(&rule); err != nil {
+		log.Printf("Error creating filter rule: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	writeFilterRule(w, http.StatusCreated, rule.RuleID)
+}
+
+// UpdateFilterRuleHandler serves PATCH /api/admin/filter-rules/{id}.
+func UpdateFilterRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Filter rule not found")
+		return
+	}
+
+	rule, err := db.GetFilterRule(id)
+	if errors.Is(err, db.ErrFilterRuleNotFound) {
+		api.Fail(w, http.StatusNotFound, "Filter rule not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching filter rule: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	var input filterRuleInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	applyFilterRuleInput(&rule, input)
+
+	if errs := validateFilterRule(rule); len(errs) > 0 {
+		api.FailFields(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	err = db.UpdateFilterRule(rule)
+	switch {
+	case errors.Is(err, db.ErrFilterRuleNotFound):
+		api.Fail(w, http.StatusNotFound, "Filter rule not found")
+	case err != nil:
+		log.Printf("Error updating filter rule: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		writeFilterRule(w, http.StatusOK, rule.RuleID)
+	}
+}
+
+// DeleteFilterRuleHandler serves DELETE /api/admin/filter-rules/{id}.
+func DeleteFilterRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Filter rule not found")
+		return
+	}
+
+	err = db.DeleteFilterRule(id)
+	switch {
+	case errors.Is(err, db.ErrFilterRuleNotFound):
+		api.Fail(w, http.StatusNotFound, "Filter rule not found")
+	case err != nil:
+		log.Printf("Error deleting filter rule: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	}
+}
+
+// writeFilterRule answers with a rule as it is stored, timestamps included.
+func writeFilterRule(w http.ResponseWriter, status int, ruleID int) {
+	rule, err := db.GetFilterRule(ruleID)
+	if err != nil {
+		log.Printf("Error fetching filter rule: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	api.WriteJSON(w, status, rule)
+}
+
+func applyFilterRuleInput(rule *models.FilterRule, input filterRuleInput) {
+	if input.Kind != nil {
+		rule.Kind = strings.TrimSpace(*input.Kind)
+	}
+	if input.Pattern != nil {
+		rule.Pattern = *input.Pattern
+		if rule.Kind == filter.KindWord {
+			rule.Pattern = strings.TrimSpace(rule.Pattern)
+		}
+	}
+	if input.Action != nil {
+		rule.Action = strings.TrimSpace(*input.Action)
+	}
+	if input.Note != nil {
+		rule.Note = strings.TrimSpace(*input.Note)
+	}
+}
+
+func validateFilterRule(rule models.FilterRule) map[string]string {
+	errors := make(map[string]string)
+
+	if rule.Kind != filter.KindWord && rule.Kind != filter.KindRegex {
+		errors["kind"] = "Kind must be word or regex"
+	}
+
+	if rule.Pattern == "" {
+		errors["pattern"] = "Pattern is required"
+	} else if len(rule.Pattern) > maxFilterPatternLength {
+		errors["pattern"] = "Pattern must be at most " + strconv.Itoa(maxFilterPatternLength) + " characters"
+	} else if _, ok := errors["kind"]; !ok {
+		if _, err := filter.Compile(rule.Kind, rule.Pattern); err != nil {
+			errors["pattern"] = "Invalid regular expression: " + err.Error()
+		}
+	}
+
+	if !filter.ValidAction(rule.Action) {
+		errors["action"] = "Action must be reject, hold or shadow"
+	}
+
+	if len(rule.Note) > maxFilterNoteLength {
+		errors["note"] = "Note must be at most " + strconv.Itoa(maxFilterNoteLength) + " characters"
+	}
+
+	return errors
+}
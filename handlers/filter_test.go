@@ -0,0 +1,81 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"real/api"
+)
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope struct {
+		Error api.Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return envelope.Error.Code
+}
+
+// A post that fails after the filter ran must not count as a duplicate of
+// the same post sent again.
+func TestFailedPostIsNotDuplicate(t *testing.T) {
+	_, session := newSession(t)
+	title := "Failed then retried"
+	content := "This post fails the first time because of its image."
+
+	w := serve(CreatePostHandler, postForm(t, title, content, []byte("not an image at all")), session)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("post with a bad image: got %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+
+	w = serve(CreatePostHandler, postForm(t, title, content), session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("retried post: got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w = serve(CreatePostHandler, postForm(t, title, content), session)
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != api.CodeRejected {
+		t.Fatalf("posting it again: got %d %s, want a duplicate rejection", w.Code, w.Body)
+	}
+}
+
+// Likewise for a comment the database refuses.
+func TestFailedCommentIsNotDuplicate(t *testing.T) {
+	_, session := newSession(t)
+	w := serve(CreatePostHandler, postForm(t, "Somewhere to comment", "A post to hang comments from."), session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating post: got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		PostID int `json:"post_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+
+	comment := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/posts/"+strconv.Itoa(created.PostID)+"/comments", strings.NewReader(body))
+		r.SetPathValue("id", strconv.Itoa(created.PostID))
+		return serve(CreateCommentHandler, r, session)
+	}
+	text := "A reply long enough for the duplicate check."
+
+	w = comment(`{"content": "` + text + `", "parent_comment_id": 999999}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("reply to a missing comment: got %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	w = comment(`{"content": "` + text + `"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("retried comment: got %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	w = comment(`{"content": "` + text + `"}`)
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != api.CodeRejected {
+		t.Fatalf("commenting it again: got %d %s, want a duplicate rejection", w.Code, w.Body)
+	}
+}
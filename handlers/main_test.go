@@ -0,0 +1,43 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"real/auth"
+	"real/db"
+	"real/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// newSession signs up a user and returns their ID and a session cookie.
+func newSession(t *testing.T) (int, *http.Cookie) {
+	t.Helper()
+	userID := testdb.NewUser(t)
+
+	sessionID := uuid.New().String()
+	if _, err := db.DB.Exec(
+		`INSERT INTO sessions (session_id, user_id, expires_at) VALUES (?, ?, ?)`,
+		sessionID, userID, time.Now().Add(time.Hour),
+	); err != nil {
+		t.Fatal(err)
+	}
+	return userID, &http.Cookie{Name: "session_id", Value: sessionID}
+}
+
+// serve sends r through the session middleware to h as the session's user.
+func serve(h http.HandlerFunc, r *http.Request, session *http.Cookie) *httptest.ResponseRecorder {
+	r.AddCookie(session)
+	w := httptest.NewRecorder()
+	auth.SessionMiddleware(h).ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/auth"
+	"real/chat"
+	"real/models"
+	"real/storage"
+	"real/uploads"
+)
+
+// MediaHandler serves GET /media/{key...}, the uploads in media storage.
+// Post images are public. Attachments are only served to the users
+// OpenAttachment lets see them. Range requests and conditional requests
+// are answered by http.ServeContent.
+func MediaHandler(w http.ResponseWriter, r *http.Request) {
+	prefix, name, _ := strings.Cut(r.PathValue("key"), "/")
+	switch prefix {
+	case uploads.PostImagesPrefix:
+		servePostImage(w, r, name)
+	case chat.AttachmentsPrefix:
+		serveStoredAttachment(w, r, name)
+	default:
+		api.Fail(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func servePostImage(w http.ResponseWriter, r *http.Request, name string) {
+	file, info, err := uploads.Open(uploads.PostImagesPrefix, name)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		api.Fail(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening post image: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", mediaETag(info.Key, info.Size))
+	// Names are never reused, so what is under one never changes.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", info.ModTime, file)
+}
+
+func serveStoredAttachment(w http.ResponseWriter, r *http.Request, name string) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	attachment, file, err := chat.OpenStoredAttachment(userID, name)
+	if errors.Is(err, chat.ErrAttachmentNotFound) {
+		api.Fail(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	defer file.Close()
+
+	serveAttachment(w, r, attachment, file)
+}
+
+// serveAttachment writes an attachment the user may see. Images are shown
+// inline; anything else is downloaded under the name it was uploaded with.
+func serveAttachment(w http.ResponseWriter, r *http.Request, attachment models.Attachment, file io.ReadSeekCloser) {
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", mediaETag(storage.Key(chat.AttachmentsPrefix, attachment.StoredName), attachment.Size))
+	// Only the requester may see it, so shared caches must not keep it, and
+	// access ends with the conversation, so browsers keep it only a while.
+	w.Header().Set("Cache-Control", "private, max-age=3600, immutable")
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
+
+// mediaETag is a strong validator for the object under key. Objects are
+// never overwritten, so the key and size identify the bytes exactly.
+func mediaETag(key string, size int64) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + strconv.FormatInt(size, 10)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
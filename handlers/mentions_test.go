@@ -0,0 +1,131 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"real/chat"
+	"real/db"
+)
+
+func username(t *testing.T, userID int) string {
+	t.Helper()
+	var name string
+	if err := db.DB.QueryRow(`SELECT username FROM users WHERE user_id = ?`, userID).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// mentionLink is how Render links a mention of name.
+func mentionLink(name string) string {
+	return `<a class="mention" href="/profile/` + name + `">@` + name + `</a>`
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestPostContentHTML(t *testing.T) {
+	_, session := newSession(t)
+	mentionedID, _ := newSession(t)
+	name := username(t, mentionedID)
+	want := "Ask " + mentionLink(name) + " &lt;b&gt;now&lt;/b&gt;"
+
+	w := serve(CreatePostHandler, postForm(t, "A mention", "Ask @"+name+" <b>now</b>"), session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating post: got %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		PostID      int    `json:"post_id"`
+		ContentHTML string `json:"content_html"`
+	}
+	decode(t, w, &created)
+	if created.ContentHTML != want {
+		t.Errorf("created post content_html %q, want %q", created.ContentHTML, want)
+	}
+
+	w = serve(ListPostsHandler, httptest.NewRequest(http.MethodGet, "/api/posts", nil), session)
+	var feed struct {
+		Posts []struct {
+			PostID      int    `json:"post_id"`
+			ContentHTML string `json:"content_html"`
+		} `json:"posts"`
+	}
+	decode(t, w, &feed)
+	found := false
+	for _, p := range feed.Posts {
+		if p.PostID == created.PostID {
+			found = true
+			if p.ContentHTML != want {
+				t.Errorf("feed content_html %q, want %q", p.ContentHTML, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("post %d is not in the feed", created.PostID)
+	}
+}
+
+func TestMessageContentHTML(t *testing.T) {
+	senderID, session := newSession(t)
+	otherID, _ := newSession(t)
+	name := username(t, otherID)
+	conversation, err := chat.OpenDirect(senderID, otherID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversationID := strconv.Itoa(conversation.ConversationID)
+
+	type message struct {
+		MessageID   int64  `json:"message_id"`
+		ContentHTML string `json:"content_html"`
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/conversations/"+conversationID+"/messages",
+		strings.NewReader(`{"content": "hey @`+name+` & co"}`))
+	r.SetPathValue("id", conversationID)
+	w := serve(SendMessageHandler, r, session)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("sending message: got %d: %s", w.Code, w.Body)
+	}
+	var sent message
+	decode(t, w, &sent)
+	if want := "hey " + mentionLink(name) + " &amp; co"; sent.ContentHTML != want {
+		t.Errorf("sent message content_html %q, want %q", sent.ContentHTML, want)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/conversations/"+conversationID+"/messages", nil)
+	r.SetPathValue("id", conversationID)
+	w = serve(ListMessagesHandler, r, session)
+	var history struct {
+		Messages []message `json:"messages"`
+	}
+	decode(t, w, &history)
+	if len(history.Messages) != 1 || history.Messages[0].ContentHTML != sent.ContentHTML {
+		t.Errorf("history %+v, want the sent message's content_html", history.Messages)
+	}
+
+	messageID := strconv.FormatInt(sent.MessageID, 10)
+	r = httptest.NewRequest(http.MethodPatch, "/api/messages/"+messageID,
+		strings.NewReader(`{"content": "@`+name+` <i>edited</i>"}`))
+	r.SetPathValue("id", messageID)
+	w = serve(EditMessageHandler, r, session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("editing message: got %d: %s", w.Code, w.Body)
+	}
+	var edited message
+	decode(t, w, &edited)
+	if want := mentionLink(name) + " &lt;i&gt;edited&lt;/i&gt;"; edited.ContentHTML != want {
+		t.Errorf("edited message content_html %q, want %q", edited.ContentHTML, want)
+	}
+}
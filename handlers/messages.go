@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real/api"
+	"real/auth"
+	"real/chat"
+	"real/config"
+	"real/db"
+	"real/realtime"
+)
+
+const (
+	defaultMessageLimit = 10
+	maxMessageLimit     = 100
+)
+
+// ListChatUsersHandler serves GET /api/users, the chat sidebar's user list
+// with online status and unread counts. Users who blocked the requester
+// always show as offline.
+func ListChatUsersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	users, err := db.ListChatUsers(userID)
+	if err != nil {
+		log.Printf("Error fetching chat users: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	blockers, err := db.BlockerIDs(userID)
+	if err != nil {
+		log.Printf("Error fetching blocks: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	for i := range users {
+		users[i].Online = !blockers[users[i].UserID] && realtime.IsOnline(users[i].UserID)
+	}
+
+	api.WriteJSON(w, http.StatusOK, users)
+}
+
+// OpenDirectConversationHandler serves POST /api/conversations/direct with a
+// JSON body of {"user_id": ...}.
+func OpenDirectConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, err := chat.OpenDirect(userID, input.UserID)
+	if err != nil {
+		writeChatError(w, err, "opening conversation")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// ListConversationsHandler serves GET /api/conversations, every conversation
+// the user is in with its latest message and unread count.
+func ListConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversations, err := chat.List(userID)
+	if err != nil {
+		writeChatError(w, err, "fetching conversations")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversations)
+}
+
+// CreateGroupHandler serves POST /api/conversations with a JSON body of
+// {"title": "...", "user_ids": [...]}. The caller owns the new group.
+func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		Title   string `json:"title"`
+		UserIDs []int  `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, err := chat.CreateGroup(userID, input.Title, input.UserIDs)
+	if err != nil {
+		writeChatError(w, err, "creating group")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusCreated, conversation)
+}
+
+// GetConversationHandler serves GET /api/conversations/{id}.
+func GetConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, err := chat.Get(userID, conversationID)
+	if err != nil {
+		writeChatError(w, err, "fetching conversation")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// RenameConversationHandler serves PATCH /api/conversations/{id} with a JSON
+// body of {"title": "..."}. Only the group's owner can rename it.
+func RenameConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, err := chat.Rename(userID, conversationID, input.Title)
+	if err != nil {
+		writeChatError(w, err, "renaming conversation")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// AddMembersHandler serves POST /api/conversations/{id}/members with a JSON
+// body of {"user_ids": [...]}. Only the group's owner can add members.
+func AddMembersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, err := chat.AddMembers(userID, conversationID, input.UserIDs)
+	if err != nil {
+		writeChatError(w, err, "adding members")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// RemoveMemberHandler serves DELETE /api/conversations/{id}/members/{userID}.
+// The owner can remove anyone; other members can only remove themselves.
+func RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "User is not a member of this conversation")
+		return
+	}
+
+	conversation, err := chat.RemoveMember(userID, conversationID, memberID)
+	if err != nil {
+		writeChatError(w, err, "removing member")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, conversation)
+}
+
+// LeaveConversationHandler serves POST /api/conversations/{id}/leave.
+func LeaveConversationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := chat.RemoveMember(userID, conversationID, userID); err != nil {
+		writeChatError(w, err, "leaving conversation")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// ListMessagesHandler serves
+// GET /api/conversations/{id}/messages?limit=...&before=...
+// Messages come oldest first; pass next_before from one page as before to get
+// the older page.
+func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+
+	limit := defaultMessageLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxMessageLimit)
+	}
+
+	var before int64
+	if v := params.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		before = n
+	}
+
+	messages, err := chat.History(userID, conversationID, before, limit)
+	if err != nil {
+		writeChatError(w, err, "fetching messages")
+		return
+	}
+
+	var nextBefore int64
+	if len(messages) == limit {
+		nextBefore = messages[0].MessageID
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"messages":    messages,
+		"next_before": nextBefore,
+	})
+}
+
+// SendMessageHandler serves POST /api/conversations/{id}/messages with a JSON
+// body of {"content": "...", "client_msg_id": "...", "attachment_ids": [...],
+// "upload_ids": [...]}. attachment_ids come from POST /api/attachments and
+// upload_ids from finished resumable uploads; both are optional, as is
+// client_msg_id. Retrying with the same client_msg_id returns the stored
+// message with 200 instead of sending it twice.
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input sendMessageInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	attachmentIDs, err := input.attachments(userID)
+	if err != nil {
+		writeChatError(w, err, "sending message")
+		return
+	}
+
+	msg, created, err := chat.Send(userID, conversationID, input.Content, input.ClientMsgID, attachmentIDs)
+	if err != nil {
+		writeChatError(w, err, "sending message")
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	api.WriteJSON(w, status, msg)
+}
+
+type sendMessageInput struct {
+	ConversationID int      `json:"conversation_id"`
+	Content        string   `json:"content"`
+	ClientMsgID    string   `json:"client_msg_id"`
+	AttachmentIDs  []int64  `json:"attachment_ids"`
+	UploadIDs      []string `json:"upload_ids"`
+}
+
+// attachments returns the IDs of the attachments to send, with those made
+// from the resumable uploads named.
+func (input sendMessageInput) attachments(userID int) ([]int64, error) {
+	if len(input.UploadIDs) == 0 {
+		return input.AttachmentIDs, nil
+	}
+	ids, err := chat.AttachUploads(userID, input.UploadIDs)
+	if err != nil {
+		return nil, err
+	}
+	return append(input.AttachmentIDs, ids...), nil
+}
+
+// SocketSendMessage handles send_message requests over the WebSocket, with
+// data of {"conversation_id": ..., "content": "...", "client_msg_id": "...",
+// "attachment_ids": [...], "upload_ids": [...]}.
+// The ack carries the stored message. client_msg_id is required here, so a
+// client that lost its connection before the ack can safely send again.
+func SocketSendMessage(userID int, data json.RawMessage) (interface{}, error) {
+	var input sendMessageInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, realtime.ClientError("Invalid request body")
+	}
+	if input.ClientMsgID == "" {
+		return nil, realtime.ClientError("client_msg_id is required")
+	}
+
+	attachmentIDs, err := input.attachments(userID)
+	if message, ok := chatErrorMessage(err); ok {
+		return nil, realtime.ClientError(message)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg, _, err := chat.Send(userID, input.ConversationID, input.Content, input.ClientMsgID, attachmentIDs)
+	if message, ok := chatErrorMessage(err); ok {
+		return nil, realtime.ClientError(message)
+	}
+	return msg, err
+}
+
+// EditMessageHandler serves PATCH /api/messages/{id} with a JSON body of
+// {"content": "..."}. Only the sender can edit a message, and only within
+// the configured edit window.
+func EditMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	messageID, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	window := time.Duration(config.Current.Chat.EditWindowMinutes) * time.Minute
+	msg, err := chat.Edit(userID, messageID, input.Content, window)
+	if err != nil {
+		writeChatError(w, err, "editing message")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, msg)
+}
+
+// DeleteMessageHandler serves DELETE /api/messages/{id}. The message is
+// removed for everyone and the tombstone left in its place is returned.
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	messageID, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := chat.Delete(userID, messageID)
+	if err != nil {
+		writeChatError(w, err, "deleting message")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, msg)
+}
+
+// MessageEditsHandler serves GET /api/moderation/messages/{id}/edits for
+// moderators: the message as it is now, and every earlier version of it.
+// Private messages are only open to moderators once someone reports them.
+func MessageEditsHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, ok := messageIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	reported, err := db.WasReported("message", int(messageID))
+	if err != nil {
+		log.Printf("Error checking reports: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if !reported {
+		api.Fail(w, http.StatusNotFound, "Message not found")
+		return
+	}
+
+	msg, err := db.GetMessage(messageID)
+	if errors.Is(err, db.ErrMessageNotFound) {
+		api.Fail(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching message: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	edits, err := db.ListMessageEdits(messageID)
+	if err != nil {
+		log.Printf("Error fetching message edits: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": msg,
+		"edits":   edits,
+	})
+}
+
+// MarkConversationReadHandler serves POST /api/conversations/{id}/read with
+// an optional JSON body of {"message_id": ...}. Without a message ID the
+// whole conversation is marked read.
+func MarkConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	conversationID, ok := conversationIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			api.Fail(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	marker, err := chat.MarkRead(userID, conversationID, input.MessageID)
+	if err != nil {
+		writeChatError(w, err, "marking conversation read")
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]int64{"last_read_message_id": marker})
+}
+
+// GetSettingsHandler serves GET /api/me/settings.
+func GetSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	settings, err := db.GetUserSettings(userID)
+	if err != nil {
+		log.Printf("Error fetching settings: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, settings)
+}
+
+// UpdateSettingsHandler serves PATCH /api/me/settings. Fields left out of the
+// JSON body keep their current value.
+func UpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		ReadReceipts *bool `json:"read_receipts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	settings, err := db.GetUserSettings(userID)
+	if err != nil {
+		log.Printf("Error fetching settings: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if input.ReadReceipts != nil {
+		settings.ReadReceipts = *input.ReadReceipts
+	}
+
+	if err := db.UpdateUserSettings(userID, settings); err != nil {
+		log.Printf("Error updating settings: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, settings)
+}
+
+func conversationIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Conversation not found")
+		return 0, false
+	}
+	return id, true
+}
+
+func messageIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Message not found")
+		return 0, false
+	}
+	return id, true
+}
+
+// writeChatError maps errors from the chat package to responses. Anything
+// unexpected is logged with the given action and reported as a 500.
+func writeChatError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, chat.ErrNotMember):
+		// Same answer as a conversation that does not exist, so IDs cannot be probed.
+		api.Fail(w, http.StatusNotFound, "Conversation not found")
+	case errors.Is(err, chat.ErrMessageNotFound):
+		api.Fail(w, http.StatusNotFound, "Message not found")
+	case errors.Is(err, chat.ErrNotSender):
+		api.Fail(w, http.StatusForbidden, "You can only change your own messages")
+	case errors.Is(err, chat.ErrEditWindowPassed):
+		api.Fail(w, http.StatusForbidden, "Messages can only be edited for "+strconv.Itoa(config.Current.Chat.EditWindowMinutes)+" minutes after sending")
+	case errors.Is(err, chat.ErrUnknownUser):
+		api.Fail(w, http.StatusNotFound, "User not found")
+	case errors.Is(err, chat.ErrUserNotMember):
+		api.Fail(w, http.StatusNotFound, "User is not a member of this conversation")
+	case errors.Is(err, chat.ErrNotOwner):
+		api.Fail(w, http.StatusForbidden, "Only the group owner can do this")
+	case errors.Is(err, chat.ErrNotGroup):
+		api.Fail(w, http.StatusBadRequest, "Direct conversations cannot be changed")
+	case errors.Is(err, chat.ErrInvalidTitle):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"title": "Title must be between 1 and " + strconv.Itoa(chat.MaxTitleLength) + " characters"})
+	case errors.Is(err, chat.ErrNoMembers):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"user_ids": "Choose at least one user to add"})
+	case errors.Is(err, chat.ErrTooManyMembers):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"user_ids": "A group can have at most " + strconv.Itoa(chat.MaxGroupMembers) + " members"})
+	case errors.Is(err, chat.ErrInvalidClientMsgID):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"client_msg_id": "Client message ID is too long or already used in another conversation"})
+	case errors.Is(err, chat.ErrTooManyAttachments):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"attachment_ids": "A message can have at most " + strconv.Itoa(chat.MaxAttachments) + " attachments"})
+	case errors.Is(err, chat.ErrAttachmentUnavailable):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"attachment_ids": "Attachment not found or already sent"})
+	case errors.Is(err, chat.ErrUploadUnavailable):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"upload_ids": "Upload not found or not finished"})
+	case errors.Is(err, chat.ErrBlocked):
+		api.Fail(w, http.StatusForbidden, "You cannot message this user")
+	case errors.Is(err, chat.ErrRejected):
+		api.WriteError(w, api.NewError(http.StatusBadRequest, "Your message was rejected by the content filter").
+			WithCode(api.CodeRejected))
+	case errors.Is(err, chat.ErrMessageYourself):
+		api.Fail(w, http.StatusBadRequest, "You cannot message yourself")
+	case errors.Is(err, chat.ErrEmptyMessage):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"content": "Message cannot be empty"})
+	case errors.Is(err, chat.ErrMessageTooLong):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"content": "Message must be at most " + strconv.Itoa(chat.MaxMessageLength) + " characters"})
+	default:
+		log.Printf("Error %s: %v", action, err)
+		api.WriteError(w, api.Internal())
+	}
+}
+
+// chatErrorMessage returns the text to show a socket client for errors from
+// chat.Send, or false for unexpected errors.
+func chatErrorMessage(err error) (string, bool) {
+	switch {
+	case err == nil:
+		return "", false
+	case errors.Is(err, chat.ErrNotMember):
+		return "Conversation not found", true
+	case errors.Is(err, chat.ErrEmptyMessage):
+		return "Message cannot be empty", true
+	case errors.Is(err, chat.ErrMessageTooLong):
+		return "Message must be at most " + strconv.Itoa(chat.MaxMessageLength) + " characters", true
+	case errors.Is(err, chat.ErrInvalidClientMsgID):
+		return "Client message ID is too long or already used in another conversation", true
+	case errors.Is(err, chat.ErrBlocked):
+		return "You cannot message this user", true
+	case errors.Is(err, chat.ErrRejected):
+		return "Your message was rejected by the content filter", true
+	case errors.Is(err, chat.ErrTooManyAttachments):
+		return "A message can have at most " + strconv.Itoa(chat.MaxAttachments) + " attachments", true
+	case errors.Is(err, chat.ErrAttachmentUnavailable):
+		return "Attachment not found or already sent", true
+	case errors.Is(err, chat.ErrUploadUnavailable):
+		return "Upload not found or not finished", true
+	}
+	return "", false
+}
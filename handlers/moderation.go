@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+	"real/moderation"
+)
+
+const (
+	defaultModerationLimit = 20
+	maxModerationLimit     = 100
+)
+
+type reportInput struct {
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+// CreateReportHandler serves POST /api/reports with a JSON body of
+// {"target_type", "target_id", "reason", "details"}. Reporting the same
+// thing twice is not an error; the first report comes back with 200.
+func CreateReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input reportInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	report, created, err := moderation.Report(userID, input.TargetType, input.TargetID, input.Reason, input.Details)
+	switch {
+	case errors.Is(err, moderation.ErrInvalidTarget):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"target_type": "Must be post, comment, message or user"})
+	case errors.Is(err, moderation.ErrInvalidReason):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"reason": "Must be one of spam, harassment, hate, sexual, violence or other"})
+	case errors.Is(err, moderation.ErrDetailsTooLong):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"details": "Must be at most 1000 characters"})
+	case errors.Is(err, moderation.ErrTargetNotFound):
+		api.Fail(w, http.StatusNotFound, "Target not found")
+	case errors.Is(err, moderation.ErrOwnContent):
+		api.Fail(w, http.StatusBadRequest, "You cannot report yourself")
+	case err != nil:
+		log.Printf("Error creating report: %v", err)
+		api.WriteError(w, api.Internal())
+	case created:
+		api.WriteJSON(w, http.StatusCreated, report)
+	default:
+		api.WriteJSON(w, http.StatusOK, report)
+	}
+}
+
+// ListCasesHandler serves GET /api/moderation/cases?status=...&limit=...&before=...
+// for moderators. status is open (the default) or resolved. Cases come
+// newest first; pass next_before from one page as before to get the next.
+func ListCasesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = db.CaseOpen
+	}
+	if status != db.CaseOpen && status != db.CaseResolved {
+		api.Fail(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	limit, before, ok := moderationPage(w, r)
+	if !ok {
+		return
+	}
+
+	cases, err := db.ListCases(status, before, limit)
+	if err != nil {
+		log.Printf("Error fetching moderation cases: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	var nextBefore int64
+	if len(cases) == limit {
+		nextBefore = cases[len(cases)-1].CaseID
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"cases":       cases,
+		"next_before": nextBefore,
+	})
+}
+
+// GetCaseHandler serves GET /api/moderation/cases/{id} for moderators: the
+// case, every report on it, and the reported content as it is now.
+func GetCaseHandler(w http.ResponseWriter, r *http.Request) {
+	caseID, ok := caseIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := moderation.Case(caseID)
+	if errors.Is(err, moderation.ErrCaseNotFound) {
+		api.Fail(w, http.StatusNotFound, "Case not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching moderation case: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, c)
+}
+
+// CaseActionHandler serves POST /api/moderation/cases/{id}/actions for
+// moderators, with a JSON body of {"action", "note", "days"}. action is one
+// of dismiss, remove, warn, suspend or ban, and resolves the case.
+func CaseActionHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	caseID, ok := caseIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input moderation.Action
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := moderation.Act(moderatorID, caseID, input)
+	switch {
+	case errors.Is(err, moderation.ErrInvalidAction):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"action": "Must be dismiss, remove, warn, suspend or ban"})
+	case errors.Is(err, moderation.ErrNoteTooLong):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"note": "Must be at most 1000 characters"})
+	case errors.Is(err, moderation.ErrInvalidDuration):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"days": "Must be between 1 and 365"})
+	case errors.Is(err, moderation.ErrCaseNotFound):
+		api.Fail(w, http.StatusNotFound, "Case not found")
+	case errors.Is(err, moderation.ErrCaseResolved):
+		api.Fail(w, http.StatusConflict, "Case is already resolved")
+	case errors.Is(err, moderation.ErrNoTargetUser):
+		api.Fail(w, http.StatusConflict, "The user no longer exists")
+	case errors.Is(err, moderation.ErrProtectedUser):
+		api.Fail(w, http.StatusForbidden, "Moderators and admins cannot be warned, suspended or banned")
+	case err != nil:
+		log.Printf("Error acting on moderation case: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		c, err := moderation.Case(caseID)
+		if err != nil {
+			log.Printf("Error fetching moderation case: %v", err)
+			api.WriteError(w, api.Internal())
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, c)
+	}
+}
+
+type sanctionInput struct {
+	Action string `json:"action"`
+	Days   int    `json:"days"`
+	Reason string `json:"reason"`
+}
+
+// SanctionUserHandler serves POST /api/moderation/users/{id}/sanctions for
+// moderators, with a JSON body of {"action", "days", "reason"}. action is
+// suspend, for days days (7 by default), or ban, which has no end.
+func SanctionUserHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	var input sanctionInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	sanction, err := moderation.Sanction(moderatorID, userID, input.Action, input.Days, input.Reason)
+	switch {
+	case errors.Is(err, moderation.ErrInvalidAction):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"action": "Must be suspend or ban"})
+	case errors.Is(err, moderation.ErrNoteTooLong):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"reason": "Must be at most 1000 characters"})
+	case errors.Is(err, moderation.ErrInvalidDuration):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"days": "Must be between 1 and 365"})
+	case errors.Is(err, moderation.ErrUserNotFound):
+		api.Fail(w, http.StatusNotFound, "User not found")
+	case errors.Is(err, moderation.ErrProtectedUser):
+		api.Fail(w, http.StatusForbidden, "Moderators and admins cannot be suspended or banned")
+	case err != nil:
+		log.Printf("Error sanctioning user: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		api.WriteJSON(w, http.StatusCreated, sanction)
+	}
+}
+
+// UserSanctionsHandler serves GET /api/moderation/users/{id}/sanctions for
+// moderators: every suspension and ban the user was given, newest first.
+func UserSanctionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	sanctions, err := db.ListSanctions(userID)
+	if err != nil {
+		log.Printf("Error fetching sanctions: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"sanctions": sanctions})
+}
+
+// LiftSanctionHandler serves POST /api/moderation/sanctions/{id}/lift for
+// moderators, with an optional JSON body of {"note"}. It ends a suspension
+// or ban early.
+func LiftSanctionHandler(w http.ResponseWriter, r *http.Request) {
+	moderatorID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sanctionID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Sanction not found")
+		return
+	}
+
+	var input struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	sanction, err := moderation.Lift(moderatorID, sanctionID, input.Note)
+	switch {
+	case errors.Is(err, moderation.ErrNoteTooLong):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"note": "Must be at most 1000 characters"})
+	case errors.Is(err, moderation.ErrSanctionNotFound):
+		api.Fail(w, http.StatusNotFound, "Sanction not found")
+	case errors.Is(err, moderation.ErrSanctionInactive):
+		api.Fail(w, http.StatusConflict, "Sanction is no longer in force")
+	case err != nil:
+		log.Printf("Error lifting sanction: %v", err)
+		api.WriteError(w, api.Internal())
+	default:
+		api.WriteJSON(w, http.StatusOK, sanction)
+	}
+}
+
+// ModerationLogHandler serves GET /api/moderation/log?limit=...&before=...
+// for moderators: who did what and when, newest first.
+func ModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	limit, before, ok := moderationPage(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := db.ListModerationLog(before, limit)
+	if err != nil {
+		log.Printf("Error fetching moderation log: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	var nextBefore int64
+	if len(entries) == limit {
+		nextBefore = entries[len(entries)-1].LogID
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"entries":     entries,
+		"next_before": nextBefore,
+	})
+}
+
+// moderationPage reads the limit and before query parameters, writing a 400
+// and returning false when either is invalid.
+func moderationPage(w http.ResponseWriter, r *http.Request) (int, int64, bool) {
+	params := r.URL.Query()
+
+	limit := defaultModerationLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+		limit = min(n, maxModerationLimit)
+	}
+
+	var before int64
+	if v := params.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid before")
+			return 0, 0, false
+		}
+		before = n
+	}
+
+	return limit, before, true
+}
+
+func caseIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	caseID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Case not found")
+		return 0, false
+	}
+	return caseID, true
+}
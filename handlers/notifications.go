@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+)
+
+const (
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+)
+
+// ListNotificationsHandler serves GET /api/notifications?limit=...&before=...
+// Notifications come newest first; pass next_before from one page as before
+// to get the next.
+func ListNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	params := r.URL.Query()
+
+	limit := defaultNotificationLimit
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = min(n, maxNotificationLimit)
+	}
+
+	var before int64
+	if v := params.Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		before = n
+	}
+
+	notifications, err := db.ListNotifications(userID, before, limit)
+	if err != nil {
+		log.Printf("Error fetching notifications: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	unread, err := db.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	var nextBefore int64
+	if len(notifications) == limit {
+		nextBefore = notifications[len(notifications)-1].NotificationID
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"notifications": notifications,
+		"unread_count":  unread,
+		"next_before":   nextBefore,
+	})
+}
+
+// UnreadNotificationsHandler serves GET /api/notifications/unread-count.
+func UnreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	unread, err := db.CountUnreadNotifications(userID)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]int{"unread_count": unread})
+}
+
+// MarkNotificationReadHandler serves POST /api/notifications/{id}/read.
+func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	err = db.MarkNotificationRead(userID, id)
+	if errors.Is(err, db.ErrNotificationNotFound) {
+		api.Fail(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error marking notification read: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// MarkAllNotificationsReadHandler serves POST /api/notifications/read-all.
+func MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := db.MarkAllNotificationsRead(userID); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+	"real/models"
+	"real/storage"
+	"real/uploads"
+)
+
+const maxAltTextLength = 500
+
+// stagePostImage stages an image uploaded for a post with all its
+// variants. The caller discards it.
+func stagePostImage(header *multipart.FileHeader) (*uploads.StagedImage, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return uploads.StageImage(uploads.PostImagesPrefix, file, uploads.PostImages)
+}
+
+// stageUploadedImage is stagePostImage for one of the user's finished
+// resumable uploads. The caller removes the upload once the image is
+// committed.
+func stageUploadedImage(userID int, uploadID string) (*uploads.StagedImage, error) {
+	_, file, err := uploads.OpenResumable(userID, uploadID, uploads.PurposePostImage)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return uploads.StageImage(uploads.PostImagesPrefix, file, uploads.PostImages)
+}
+
+// removeUploads removes resumable uploads that became post images.
+func removeUploads(uploadIDs []string) {
+	for _, id := range uploadIDs {
+		if err := uploads.RemoveResumable(id); err != nil {
+			log.Printf("Error removing upload %s: %v", id, err)
+		}
+	}
+}
+
+// postImageError is what to tell the user when staging an image failed
+// because of the file, or "" when it failed for another reason.
+func postImageError(err error) string {
+	switch {
+	case errors.Is(err, uploads.ErrUploadNotFound), errors.Is(err, uploads.ErrUploadIncomplete):
+		return "Upload not found or not finished"
+	case errors.Is(err, uploads.ErrUnsupportedType):
+		return "Only JPEG, PNG, GIF and WebP images are allowed"
+	case errors.Is(err, uploads.ErrInvalidImage):
+		return "Image could not be read"
+	case errors.Is(err, uploads.ErrTooLarge):
+		return "Images must be at most " + strconv.FormatInt(uploads.PostImages.MaxSize>>20, 10) + " MB"
+	case errors.Is(err, uploads.ErrImageTooLarge):
+		return "Images must be at most " + strconv.Itoa(uploads.PostImages.MaxWidth) + "x" +
+			strconv.Itoa(uploads.PostImages.MaxHeight) + " pixels"
+	}
+	return ""
+}
+
+func validateAltText(alt string) string {
+	if utf8.RuneCountInString(alt) > maxAltTextLength {
+		return "Alt text must be at most " + strconv.Itoa(maxAltTextLength) + " characters"
+	}
+	return ""
+}
+
+// imageVariants converts the variants of a staged image to what
+// db.AddPostAttachment records.
+func imageVariants(image *uploads.StagedImage) map[string]models.ImageVariant {
+	variants := make(map[string]models.ImageVariant, len(image.Variants))
+	for _, v := range image.Variants {
+		variants[v.Name] = models.ImageVariant{Key: v.Key, Width: v.Width, Height: v.Height, Size: v.Size}
+	}
+	return variants
+}
+
+// removeImages deletes stored images nothing refers to any more. Failures
+// are only logged, since uploads.SweepPostImages retries them.
+func removeImages(keys []string) {
+	for _, key := range keys {
+		if err := storage.Media.Delete(key); err != nil {
+			log.Printf("Error removing post image %s: %v", key, err)
+		}
+	}
+}
+
+// editablePost returns the post in the request's path if the user wrote it,
+// and otherwise answers the request and returns false.
+func editablePost(w http.ResponseWriter, r *http.Request, userID int) (models.Post, bool) {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Post not found")
+		return models.Post{}, false
+	}
+
+	post, err := db.GetPost(postID)
+	if errors.Is(err, db.ErrPostNotFound) {
+		api.Fail(w, http.StatusNotFound, "Post not found")
+		return models.Post{}, false
+	}
+	if err != nil {
+		log.Printf("Error fetching post: %v", err)
+		api.WriteError(w, api.Internal())
+		return models.Post{}, false
+	}
+	if post.UserID != userID {
+		api.Fail(w, http.StatusForbidden, "You can only edit your own posts")
+		return models.Post{}, false
+	}
+	return post, true
+}
+
+// postAttachmentFromPath returns the attachment in the request's path if it
+// belongs to post, and otherwise answers the request and returns false.
+func postAttachmentFromPath(w http.ResponseWriter, r *http.Request, post models.Post) (models.PostAttachment, bool) {
+	attachmentID, err := strconv.ParseInt(r.PathValue("attachmentID"), 10, 64)
+	if err == nil {
+		for _, a := range post.Attachments {
+			if a.AttachmentID == attachmentID {
+				return a, true
+			}
+		}
+	}
+	api.Fail(w, http.StatusNotFound, "Attachment not found")
+	return models.PostAttachment{}, false
+}
+
+// AddPostAttachmentHandler serves POST /api/posts/{id}/attachments, a
+// multipart form with the image in "img", or the ID of a finished resumable
+// upload of it in "upload_id", and its alt text in "alt". The image goes
+// after the post's others.
+func AddPostAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	post, ok := editablePost(w, r, userID)
+	if !ok {
+		return
+	}
+	if len(post.Attachments) >= db.MaxPostAttachments {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"img": "A post can have at most " + strconv.Itoa(db.MaxPostAttachments) + " images"})
+		return
+	}
+
+	// Leave room for the multipart headers around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, uploads.PostImages.MaxSize+1<<20)
+	if err := r.ParseMultipartForm(20 << 20); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			api.FailFields(w, http.StatusBadRequest, map[string]string{"img": postImageError(uploads.ErrTooLarge)})
+			return
+		}
+		api.Fail(w, http.StatusBadRequest, "Failed to parse form")
+		return
+	}
+	alt := strings.TrimSpace(r.FormValue("alt"))
+	if msg := validateAltText(alt); msg != "" {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"alt": msg})
+		return
+	}
+	files := r.MultipartForm.File["img"]
+	uploadIDs := r.MultipartForm.Value["upload_id"]
+	if len(files)+len(uploadIDs) != 1 {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"img": "Choose one image to upload"})
+		return
+	}
+
+	field := "img"
+	var image *uploads.StagedImage
+	var err error
+	if len(uploadIDs) == 1 {
+		field = "upload_id"
+		image, err = stageUploadedImage(userID, uploadIDs[0])
+	} else {
+		image, err = stagePostImage(files[0])
+	}
+	if msg := postImageError(err); msg != "" {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{field: msg})
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving image: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	defer image.Discard()
+
+	// Store the image before attaching it, so the post never refers to one
+	// that could not be stored, and remove it again if attaching fails.
+	if err := image.Commit(); err != nil {
+		log.Printf("Error storing image: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	attachmentID, err := addPostAttachment(post.PostID, alt, image)
+	if err != nil {
+		var keys []string
+		for _, v := range image.Variants {
+			keys = append(keys, v.Key)
+		}
+		removeImages(keys)
+	}
+	if errors.Is(err, db.ErrTooManyAttachments) {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"img": "A post can have at most " + strconv.Itoa(db.MaxPostAttachments) + " images"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding post attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	removeUploads(uploadIDs)
+
+	attachment, err := db.GetPostAttachment(attachmentID)
+	if err != nil {
+		log.Printf("Error fetching post attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, attachment)
+}
+
+func addPostAttachment(postID int, alt string, image *uploads.StagedImage) (int64, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := db.AddPostAttachment(tx, postID, alt, image.ContentType, imageVariants(image))
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+type postAttachmentInput struct {
+	AltText  *string `json:"alt_text"`
+	Position *int    `json:"position"`
+}
+
+// UpdatePostAttachmentHandler serves PATCH
+// /api/posts/{id}/attachments/{attachmentID}, which changes an image's alt
+// text or moves it to another position among the post's images.
+func UpdatePostAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	post, ok := editablePost(w, r, userID)
+	if !ok {
+		return
+	}
+	attachment, ok := postAttachmentFromPath(w, r, post)
+	if !ok {
+		return
+	}
+
+	var input postAttachmentInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.AltText != nil {
+		alt := strings.TrimSpace(*input.AltText)
+		if msg := validateAltText(alt); msg != "" {
+			api.FailFields(w, http.StatusBadRequest, map[string]string{"alt_text": msg})
+			return
+		}
+		input.AltText = &alt
+	}
+
+	err := db.UpdatePostAttachment(attachment.AttachmentID, input.AltText, input.Position)
+	if err == nil {
+		attachment, err = db.GetPostAttachment(attachment.AttachmentID)
+	}
+	if errors.Is(err, db.ErrPostAttachmentNotFound) {
+		api.Fail(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating post attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, attachment)
+}
+
+// DeletePostAttachmentHandler serves DELETE
+// /api/posts/{id}/attachments/{attachmentID}. The image's files go with it.
+func DeletePostAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	post, ok := editablePost(w, r, userID)
+	if !ok {
+		return
+	}
+	attachment, ok := postAttachmentFromPath(w, r, post)
+	if !ok {
+		return
+	}
+
+	keys, err := db.DeletePostAttachment(attachment.AttachmentID)
+	if errors.Is(err, db.ErrPostAttachmentNotFound) {
+		api.Fail(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting post attachment: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	removeImages(keys)
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
@@ -1,37 +1,37 @@
 package handlers
 
 import (
-	"database/sql"
-	"encoding/json"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 
+	"real/api"
 	"real/auth"
 	"real/db"
-
-	"github.com/google/uuid"
+	"real/filter"
+	"real/mentions"
+	"real/models"
+	"real/realtime"
+	"real/uploads"
 )
 
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	// Authentication check
-	userID, ok := auth.GetUserID(r)
-	if !ok || userID == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		api.Fail(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Parse form with file upload
 	if err := r.ParseMultipartForm(20 << 20); err != nil { // 20MB max
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		api.Fail(w, http.StatusBadRequest, "Failed to parse form")
 		return
 	}
 
@@ -41,87 +41,142 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	categories := r.Form["category"] // Gets all selected categories
 
 	// Validate inputs
-	if title == "" || content == "" {
-		http.Error(w, "Title and content are required", http.StatusBadRequest)
-		return
+	required := make(map[string]string)
+	if title == "" {
+		required["title"] = "Title is required"
+	}
+	if content == "" {
+		required["content"] = "Content is required"
 	}
-
 	if len(categories) == 0 {
-		http.Error(w, "At least one category is required", http.StatusBadRequest)
+		required["category"] = "At least one category is required"
+	}
+	if len(required) > 0 {
+		api.FailFields(w, http.StatusBadRequest, required)
 		return
 	}
 
-	// Process image upload if exists
-	var imgURL string
-	file, header, err := r.FormFile("img")
-	if err == nil {
-		defer file.Close()
-
-		// Validate image
-		if !strings.HasSuffix(strings.ToLower(header.Filename), ".jpg") &&
-			!strings.HasSuffix(strings.ToLower(header.Filename), ".jpeg") &&
-			!strings.HasSuffix(strings.ToLower(header.Filename), ".png") {
-			http.Error(w, "Only JPG, JPEG, and PNG images are allowed", http.StatusBadRequest)
+	categoryIDs, categoryErr := parseCategoryIDs(categories)
+	if categoryErr == "" {
+		unknown, archived, err := db.CheckPostCategories(categoryIDs)
+		if err != nil {
+			log.Printf("Error checking categories: %v", err)
+			api.WriteError(w, api.Internal())
 			return
 		}
+		if len(unknown) > 0 {
+			categoryErr = "Unknown category"
+		} else if len(archived) > 0 {
+			categoryErr = "Category is archived and no longer accepts posts"
+		}
+	}
+	if categoryErr != "" {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"category": categoryErr})
+		return
+	}
 
-		// Create upload directory if not exists
-		if err := os.MkdirAll("static/images/posts", 0o755); err != nil {
-			log.Printf("Error creating upload directory: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Process image uploads, with the alt text of each in the "alt" value
+	// at the same index. Images can also come from finished resumable
+	// uploads named in "upload_id", which follow the uploaded files and
+	// take the alt texts after theirs. They are staged until the post is
+	// committed, so a failed request leaves no file behind.
+	files := r.MultipartForm.File["img"]
+	uploadIDs := r.MultipartForm.Value["upload_id"]
+	alts := r.MultipartForm.Value["alt"]
+	if len(files)+len(uploadIDs) > db.MaxPostAttachments {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{
+			"img": "A post can have at most " + strconv.Itoa(db.MaxPostAttachments) + " images",
+		})
+		return
+	}
+	var images []*uploads.StagedImage
+	defer func() {
+		for _, image := range images {
+			image.Discard()
+		}
+	}()
+	imageAlts := make([]string, len(files)+len(uploadIDs))
+	for i := range imageAlts {
+		if i < len(alts) {
+			imageAlts[i] = strings.TrimSpace(alts[i])
+		}
+		if msg := validateAltText(imageAlts[i]); msg != "" {
+			api.FailFields(w, http.StatusBadRequest, map[string]string{"alt": msg})
 			return
 		}
 
-		// Create unique filename
-		uniqueID := uuid.New().String()
-		ext := filepath.Ext(header.Filename)
-		newFilename := uniqueID + ext
-		dstPath := filepath.Join("static", "images", "posts", newFilename)
-
-		// Save file
-		dst, err := os.Create(dstPath)
+		field := "img"
+		var image *uploads.StagedImage
+		var err error
+		if i < len(files) {
+			image, err = stagePostImage(files[i])
+		} else {
+			field = "upload_id"
+			image, err = stageUploadedImage(userID, uploadIDs[i-len(files)])
+		}
+		if msg := postImageError(err); msg != "" {
+			api.FailFields(w, http.StatusBadRequest, map[string]string{field: msg})
+			return
+		}
 		if err != nil {
-			log.Printf("Error creating file: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			log.Printf("Error saving image: %v", err)
+			api.WriteError(w, api.Internal())
 			return
 		}
-		defer dst.Close()
+		images = append(images, image)
+	}
 
-		if _, err := io.Copy(dst, file); err != nil {
-			log.Printf("Error saving file: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+	verdict, err := filter.Check(userID, title+"\n"+content)
+	if err != nil {
+		log.Printf("Error filtering post: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+	if verdict.Action == filter.Reject {
+		api.WriteError(w, api.NewError(http.StatusBadRequest, "Your post was rejected by the content filter").
+			WithCode(api.CodeRejected))
+		return
+	}
+
+	// Store the images before saving the post, so it never refers to one
+	// that could not be stored. They are removed again unless the post is
+	// saved.
+	var stored []string
+	saved := false
+	defer func() {
+		if !saved {
+			removeImages(stored)
+		}
+	}()
+	for _, image := range images {
+		if err := image.Commit(); err != nil {
+			log.Printf("Error storing image: %v", err)
+			api.WriteError(w, api.Internal())
 			return
 		}
-
-		imgURL = "/images/posts/" + newFilename
+		for _, v := range image.Variants {
+			stored = append(stored, v.Key)
+		}
 	}
 
 	// Start database transaction
 	tx, err := db.DB.Begin()
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		api.WriteError(w, api.Internal())
 		return
 	}
 	defer tx.Rollback()
 
 	// Insert post
-	var result sql.Result
-	if imgURL != "" {
-		result, err = tx.Exec(
-			"INSERT INTO posts (user_id, title, content, imgurl) VALUES (?, ?, ?, ?)",
-			userID, title, content, imgURL,
-		)
-	} else {
-		result, err = tx.Exec(
-			"INSERT INTO posts (user_id, title, content) VALUES (?, ?, ?)",
-			userID, title, content,
-		)
-	}
+	result, err := tx.Exec(
+		"INSERT INTO posts (user_id, title, content, visibility) VALUES (?, ?, ?, ?)",
+		userID, title, content, verdict.Visibility(),
+	)
 
 	if err != nil {
 		log.Printf("Error inserting post: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		api.WriteError(w, api.Internal())
 		return
 	}
 
@@ -129,19 +184,27 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	postID, err := result.LastInsertId()
 	if err != nil {
 		log.Printf("Error getting post ID: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		api.WriteError(w, api.Internal())
 		return
 	}
 
 	// Insert categories
-	for _, catID := range categories {
+	for _, catID := range categoryIDs {
 		_, err = tx.Exec(
 			"INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)",
 			postID, catID,
 		)
 		if err != nil {
 			log.Printf("Error inserting category: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			api.WriteError(w, api.Internal())
+			return
+		}
+	}
+
+	for i, image := range images {
+		if _, err := db.AddPostAttachment(tx, int(postID), imageAlts[i], image.ContentType, imageVariants(image)); err != nil {
+			log.Printf("Error inserting attachment: %v", err)
+			api.WriteError(w, api.Internal())
 			return
 		}
 	}
@@ -149,31 +212,155 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
 		log.Printf("Error committing transaction: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		api.WriteError(w, api.Internal())
 		return
 	}
+	saved = true
+	if err := filter.Remember(userID, verdict); err != nil {
+		log.Printf("Error remembering post: %v", err)
+	}
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	removeUploads(uploadIDs)
+
+	response := map[string]interface{}{
 		"success": true,
 		"message": "Post created successfully",
 		"post_id": postID,
+	}
+
+	// Held or hidden posts reach nobody else until a moderator publishes
+	// them.
+	if verdict.Action != filter.Allow {
+		if err := filter.Flag(mentions.ContentPost, int(postID), userID, verdict); err != nil {
+			log.Printf("Error flagging post: %v", err)
+		}
+		if verdict.Action == filter.Hold {
+			response["held"] = true
+			response["message"] = "Post is waiting for a moderator's review"
+		}
+	} else {
+		if err := mentions.Record(mentions.ContentPost, int(postID), userID, content); err != nil {
+			log.Printf("Error recording mentions: %v", err)
+		}
+
+		realtime.Broadcast(realtime.EventPostCreated, map[string]interface{}{
+			"post_id": postID,
+			"user_id": userID,
+			"title":   title,
+		})
+	}
+
+	// Fetched once mentions are recorded, so its HTML links them.
+	if post, err := db.GetPost(int(postID)); err != nil {
+		log.Printf("Error fetching post: %v", err)
+	} else {
+		posts := []models.Post{post}
+		if err := renderPosts(posts); err != nil {
+			log.Printf("Error rendering post: %v", err)
+		}
+		response["attachments"] = posts[0].Attachments
+		response["content_html"] = posts[0].ContentHTML
+	}
+
+	// Return success response
+	api.WriteJSON(w, http.StatusOK, response)
+}
+
+
+const (
+	defaultFeedLimit = 20
+	maxFeedLimit     = 100
+)
+
+// ListPostsHandler serves GET /api/posts?category=...&limit=...&before=...,
+// the feed, newest first. Signed-in users do not see posts from users they
+// blocked or muted. Pass next_before from one page as before to get the
+// next one.
+func ListPostsHandler(w http.ResponseWriter, r *http.Request) {
+	opts := db.FeedOptions{Limit: defaultFeedLimit}
+	opts.ViewerID, _ = auth.CurrentUserID(r)
+
+	params := r.URL.Query()
+	if v := params.Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid category")
+			return
+		}
+		opts.CategoryID = id
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		opts.Limit = min(n, maxFeedLimit)
+	}
+	if v := params.Get("before"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid before")
+			return
+		}
+		opts.Before = n
+	}
+
+	posts, err := db.ListPosts(opts)
+	if err != nil {
+		log.Printf("Error listing posts: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	if err := renderPosts(posts); err != nil {
+		log.Printf("Error rendering posts: %v", err)
+	}
+
+	var nextBefore int
+	if len(posts) == opts.Limit {
+		nextBefore = posts[len(posts)-1].PostID
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"posts":       posts,
+		"next_before": nextBefore,
 	})
 }
 
+// renderPosts fills in ContentHTML, linking the mentions recorded for each
+// post.
+func renderPosts(posts []models.Post) error {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.PostID
+	}
+
+	recorded, err := db.GetMentions(mentions.ContentPost, ids)
+	if err != nil {
+		return err
+	}
 
-func GetCategoriesHandler(w http.ResponseWriter, r *http.Request) {
-    categories, err := db.GetAllCategories()
-    if err != nil {
-        log.Printf("Error fetching categories: %v", err)
-        http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
-        return
-    }
-
-    w.Header().Set("Content-Type", "application/json")
-    if err := json.NewEncoder(w).Encode(categories); err != nil {
-        log.Printf("Error encoding categories: %v", err)
-        http.Error(w, "Internal server error", http.StatusInternalServerError)
-    }
-}
\ No newline at end of file
+	for i := range posts {
+		posts[i].ContentHTML = mentions.Render(posts[i].Content, recorded[posts[i].PostID])
+	}
+	return nil
+}
+
+// parseCategoryIDs converts the submitted category values to unique IDs. The
+// returned message is non-empty when a value is not a valid ID.
+func parseCategoryIDs(values []string) ([]int, string) {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, v := range values {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, "Unknown category"
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, ""
+}
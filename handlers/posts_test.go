@@ -0,0 +1,122 @@
+//go:build sqlite_fts5
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"real/db"
+	"real/storage"
+)
+
+// postForm builds a request to create a post in the first category, with
+// images as its "img" files.
+func postForm(t *testing.T, title, content string, images ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("title", title)
+	form.WriteField("content", content)
+	form.WriteField("category", "1")
+	for _, image := range images {
+		part, err := form.CreateFormFile("img", "image.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/post/create", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	img.Set(3, 3, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// failingStorage refuses every Put after the first ok ones, and tracks the
+// keys it holds.
+type failingStorage struct {
+	storage.Storage
+	mu   sync.Mutex
+	ok   int
+	keys map[string]bool
+}
+
+func (s *failingStorage) Put(key string, r io.Reader, size int64, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ok == 0 {
+		return errors.New("storage unavailable")
+	}
+	s.ok--
+	s.keys[key] = true
+	return s.Storage.Put(key, r, size, contentType)
+}
+
+func (s *failingStorage) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.keys, key)
+	s.mu.Unlock()
+	return s.Storage.Delete(key)
+}
+
+// A post whose images cannot all be stored is not saved, and the images
+// that were stored are removed.
+func TestCreatePostImageStoreFails(t *testing.T) {
+	_, session := newSession(t)
+	media := &failingStorage{Storage: storage.NewLocal(t.TempDir()), ok: 1, keys: map[string]bool{}}
+	previous := storage.Media
+	storage.Use(media)
+	t.Cleanup(func() { storage.Use(previous) })
+
+	title := "Images that fail to store"
+	w := serve(CreatePostHandler, postForm(t, title, "Two images, one of which fails.", pngImage(t), pngImage(t)), session)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+	var posts int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM posts WHERE title = ?`, title).Scan(&posts); err != nil {
+		t.Fatal(err)
+	}
+	if posts != 0 {
+		t.Errorf("%d posts saved", posts)
+	}
+	if len(media.keys) != 0 {
+		t.Errorf("images left in storage: %v", media.keys)
+	}
+
+	media.ok = 100
+	w = serve(CreatePostHandler, postForm(t, title, "Two images, both stored this time.", pngImage(t), pngImage(t)), session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var created struct {
+		Attachments []struct {
+			AttachmentID int64 `json:"attachment_id"`
+		} `json:"attachments"`
+	}
+	decode(t, w, &created)
+	if len(created.Attachments) != 2 || len(media.keys) == 0 {
+		t.Errorf("%d attachments, %d objects stored", len(created.Attachments), len(media.keys))
+	}
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 	"regexp"
@@ -10,22 +9,20 @@ import (
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"real/api"
 	"real/db"
 )
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-    // Set content type first
-    w.Header().Set("Content-Type", "application/json")
-
     if r.Method != http.MethodPost {
-        writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "Only POST method allowed"})
+        api.Fail(w, http.StatusMethodNotAllowed, "Only POST method allowed")
         return
     }
 
     // Parse form data
     if err := r.ParseMultipartForm(10 << 20); err != nil {
         log.Println("Form parse error:", err)
-        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Failed to parse form"})
+        api.Fail(w, http.StatusBadRequest, "Failed to parse form")
         return
     }
 
@@ -56,7 +53,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     )
 
     if len(errors) > 0 {
-        writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errors})
+        api.FailFields(w, http.StatusBadRequest, errors)
         return
     }
 
@@ -67,7 +64,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     tx, err := db.DB.Begin()
     if err != nil {
         log.Printf("Transaction begin error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
     defer tx.Rollback() // Safe to call if tx is already committed
@@ -82,21 +79,19 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
     if err != nil {
         log.Printf("Database error checking user existence: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
 
     if emailExists || usernameExists {
-        errorResponse := map[string]interface{}{
-            "errors": make(map[string]string),
-        }
+        taken := make(map[string]string)
         if emailExists {
-            errorResponse["errors"].(map[string]string)["email"] = "Email already in use"
+            taken["email"] = "Email already in use"
         }
         if usernameExists {
-            errorResponse["errors"].(map[string]string)["username"] = "Username already taken"
+            taken["username"] = "Username already taken"
         }
-        writeJSON(w, http.StatusConflict, errorResponse)
+        api.FailFields(w, http.StatusConflict, taken)
         return
     }
 
@@ -104,7 +99,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     hashedPassword, err := bcrypt.GenerateFromPassword([]byte(formData["password"]), bcrypt.DefaultCost)
     if err != nil {
         log.Printf("Password hashing error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
 
@@ -125,14 +120,14 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 
     if err != nil {
         log.Printf("User insert error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Registration failed"})
+        api.Fail(w, http.StatusInternalServerError, "Registration failed")
         return
     }
 
     userID, err := result.LastInsertId()
     if err != nil {
         log.Printf("LastInsertId error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
 
@@ -146,14 +141,14 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     )
     if err != nil {
         log.Printf("Session creation error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
 
     // Commit transaction
     if err := tx.Commit(); err != nil {
         log.Printf("Transaction commit error: %v", err)
-        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+        api.WriteError(w, api.Internal())
         return
     }
 
@@ -169,7 +164,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     })
 
     // Success response
-    writeJSON(w, http.StatusCreated, map[string]interface{}{
+    api.WriteJSON(w, http.StatusCreated, map[string]interface{}{
         "success": true,
         "message": "Registration successful",
         "user": map[string]interface{}{
@@ -180,16 +175,6 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
     })
 }
 
-// Reusable helper to write JSON responses
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(status)
-    if err := json.NewEncoder(w).Encode(data); err != nil {
-        log.Printf("JSON encode error: %v", err)
-        http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-    }
-}
-
 // Your existing validation function
 func validateRegistrationInput(username, ageStr, gender, firstname, lastname, email, password, confirmpassword string) map[string]string {
 	errors := make(map[string]string)
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/auth"
+	"real/models"
+	"real/uploads"
+)
+
+// Resumable uploads follow the core of tus 1.0.0 (https://tus.io), with
+// its creation, expiration and termination extensions, so tus clients can
+// send them.
+const tusVersion = "1.0.0"
+
+// tusHeaders sets the headers every tus response carries, and answers
+// requests for another version of the protocol with false.
+func tusHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		api.Fail(w, http.StatusPreconditionFailed, "Unsupported tus version")
+		return false
+	}
+	return true
+}
+
+// maxResumableSize is the largest upload any purpose allows.
+func maxResumableSize() int64 {
+	var size int64
+	for _, p := range uploads.Purposes {
+		size = max(size, p.MaxSize)
+	}
+	return size
+}
+
+// parseUploadMetadata reads an Upload-Metadata header, comma-separated
+// pairs of a key and its base64-encoded value.
+func parseUploadMetadata(header string) (map[string]string, bool) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if key == "" || err != nil {
+			return nil, false
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, true
+}
+
+func uploadLocation(u models.Upload) string {
+	return "/api/uploads/" + u.UploadID
+}
+
+func setUploadHeaders(w http.ResponseWriter, u models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// writeResumableError answers for the errors from the uploads package's
+// resumable upload functions.
+func writeResumableError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, uploads.ErrUploadNotFound):
+		api.Fail(w, http.StatusNotFound, "Upload not found")
+	case errors.Is(err, uploads.ErrUnknownPurpose):
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"purpose": "Purpose must be post_image or attachment"})
+	case errors.Is(err, uploads.ErrTooManyUploads):
+		api.Fail(w, http.StatusTooManyRequests, "You have too many uploads in progress")
+	case errors.Is(err, uploads.ErrTooLarge):
+		api.Fail(w, http.StatusRequestEntityTooLarge, "File is too large")
+	case errors.Is(err, uploads.ErrUnsupportedType):
+		api.Fail(w, http.StatusUnsupportedMediaType, "This type of file is not allowed")
+	case errors.Is(err, uploads.ErrOffsetMismatch):
+		api.Fail(w, http.StatusConflict, "Upload-Offset does not match the upload")
+	case errors.Is(err, uploads.ErrUploadBusy):
+		api.Fail(w, http.StatusLocked, "Upload is receiving data in another request")
+	default:
+		log.Printf("Error %s: %v", action, err)
+		api.WriteError(w, api.Internal())
+	}
+}
+
+// UploadOptionsHandler serves OPTIONS /api/uploads, which tells tus
+// clients what the server supports.
+func UploadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxResumableSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUploadHandler serves POST /api/uploads, which starts a resumable
+// upload of Upload-Length bytes. Upload-Metadata carries its "purpose",
+// post_image or attachment, and optionally its "filename". The upload's
+// URL is in Location, and the data is then sent to it with PATCH.
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		api.Fail(w, http.StatusBadRequest, "Upload-Length must be a positive number")
+		return
+	}
+	metadata, ok := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if !ok {
+		api.Fail(w, http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	u, err := uploads.CreateResumable(userID, metadata["purpose"], metadata["filename"], size)
+	if err != nil {
+		writeResumableError(w, err, "creating upload")
+		return
+	}
+
+	setUploadHeaders(w, u)
+	w.Header().Set("Location", uploadLocation(u))
+	api.WriteJSON(w, http.StatusCreated, u)
+}
+
+// UploadOffsetHandler serves HEAD /api/uploads/{id}, which tells a client
+// coming back to an upload how much of it arrived in Upload-Offset. Errors
+// only have their status, since HEAD answers have no body.
+func UploadOffsetHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	u, err := uploads.GetResumable(userID, r.PathValue("id"))
+	if err != nil {
+		writeResumableError(w, err, "fetching upload")
+		return
+	}
+
+	setUploadHeaders(w, u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetUploadHandler serves GET /api/uploads/{id}, the upload's progress as
+// JSON for clients that do not speak tus.
+func GetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	u, err := uploads.GetResumable(userID, r.PathValue("id"))
+	if err != nil {
+		writeResumableError(w, err, "fetching upload")
+		return
+	}
+
+	setUploadHeaders(w, u)
+	api.WriteJSON(w, http.StatusOK, u)
+}
+
+// AppendUploadHandler serves PATCH /api/uploads/{id} with a body of
+// application/offset+octet-stream, the data from Upload-Offset on. When
+// the connection drops, whatever arrived is kept, and the client resumes
+// from the offset HEAD reports.
+func AppendUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		api.Fail(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		api.Fail(w, http.StatusBadRequest, "Upload-Offset is required")
+		return
+	}
+
+	u, err := uploads.AppendResumable(userID, r.PathValue("id"), offset, r.Body)
+	if err != nil {
+		if errors.Is(err, uploads.ErrOffsetMismatch) {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+		}
+		writeResumableError(w, err, "receiving upload")
+		return
+	}
+
+	setUploadHeaders(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUploadHandler serves DELETE /api/uploads/{id}, which abandons an
+// upload and drops what arrived of it.
+func DeleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !tusHeaders(w, r) {
+		return
+	}
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	u, err := uploads.GetResumable(userID, r.PathValue("id"))
+	if err == nil {
+		err = uploads.RemoveResumable(u.UploadID)
+	}
+	if err != nil {
+		writeResumableError(w, err, "deleting upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
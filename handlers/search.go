@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"real/api"
+	"real/db"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchHandler serves GET /api/search?q=...&category=...&type=...&limit=...&offset=...
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query := strings.TrimSpace(params.Get("q"))
+	if query == "" {
+		api.Fail(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+
+	opts := db.SearchOptions{Limit: defaultSearchLimit}
+
+	if v := params.Get("category"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid category")
+			return
+		}
+		opts.CategoryID = id
+	}
+
+	switch t := params.Get("type"); t {
+	case "", "post", "comment":
+		opts.Kind = t
+	default:
+		api.Fail(w, http.StatusBadRequest, "Type must be post or comment")
+		return
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		opts.Limit = min(limit, maxSearchLimit)
+	}
+
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+		opts.Offset = offset
+	}
+
+	results, err := db.Search(query, opts)
+	if errors.Is(err, db.ErrEmptySearchQuery) {
+		api.Fail(w, http.StatusBadRequest, "Search query has no searchable words")
+		return
+	}
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"query":   query,
+		"results": results,
+		"limit":   opts.Limit,
+		"offset":  opts.Offset,
+	})
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"real/api"
+	"real/auth"
+	"real/db"
+	"real/notifications"
+)
+
+// VotePostHandler serves POST /api/posts/{id}/vote.
+func VotePostHandler(w http.ResponseWriter, r *http.Request) {
+	vote(w, r, "post")
+}
+
+// VoteCommentHandler serves POST /api/comments/{id}/vote.
+func VoteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	vote(w, r, "comment")
+}
+
+// vote applies {"type": "like"} or {"type": "dislike"} to a post or comment.
+// Sending the vote the user already cast takes it back.
+func vote(w http.ResponseWriter, r *http.Request, targetType string) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.Fail(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	var input struct {
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		api.Fail(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if input.Type != db.VoteLike && input.Type != db.VoteDislike {
+		api.FailFields(w, http.StatusBadRequest, map[string]string{"type": "Vote must be like or dislike"})
+		return
+	}
+
+	var authorID int
+	if targetType == "post" {
+		authorID, err = db.GetPostAuthor(targetID)
+	} else {
+		comment, cerr := db.GetComment(targetID)
+		if cerr == nil && comment.Deleted {
+			cerr = db.ErrCommentNotFound
+		}
+		if cerr == nil {
+			authorID = comment.UserID
+		}
+		err = cerr
+	}
+	if errors.Is(err, db.ErrPostNotFound) || errors.Is(err, db.ErrCommentNotFound) {
+		api.Fail(w, http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching %s: %v", targetType, err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	summary, previous, err := db.CastVote(userID, targetType, targetID, input.Type)
+	if err != nil {
+		log.Printf("Error casting vote: %v", err)
+		api.WriteError(w, api.Internal())
+		return
+	}
+
+	if summary.MyVote == db.VoteLike && previous != db.VoteLike {
+		if err := notifications.NotifyOnce(authorID, userID, notifications.KindLike, targetType, targetID); err != nil {
+			log.Printf("Error sending like notification: %v", err)
+		}
+	}
+
+	api.WriteJSON(w, http.StatusOK, summary)
+}
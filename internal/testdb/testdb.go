@@ -0,0 +1,93 @@
+// Package testdb runs the tests of packages that use the database against a
+// fresh one, and creates the users they need.
+package testdb
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"real/db"
+	"real/storage"
+)
+
+// path is the database file Main set up.
+var path string
+
+// Main runs m against a fresh database in a temporary directory, with media
+// storage there too, and exits with its result. db.Init reads the schema
+// from the working directory, so the tests run from the repository root.
+// Call it from the package's TestMain.
+func Main(m *testing.M) {
+	dir, err := os.MkdirTemp("", "forum-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	root, err := repositoryRoot()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		log.Fatal(err)
+	}
+	path = filepath.Join(dir, "forum.db")
+	if err := db.Init(path); err != nil {
+		log.Fatal(err)
+	}
+	storage.Use(storage.NewLocal(filepath.Join(dir, "media")))
+
+	code := m.Run()
+	db.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Reopen closes the database and opens it again, which runs its migrations
+// as a restart after an upgrade would.
+func Reopen(t testing.TB) {
+	t.Helper()
+	db.DB.Close()
+	if err := db.Init(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// repositoryRoot is the nearest directory above the working one with a
+// go.mod.
+func repositoryRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", os.ErrNotExist
+		}
+		dir = parent
+	}
+}
+
+// NewUser signs up a user with a unique name and returns their ID. The
+// account is two days old, past the filter's rules for new accounts.
+func NewUser(t testing.TB) int {
+	t.Helper()
+	name := "user" + uuid.New().String()[:8]
+	result, err := db.DB.Exec(
+		`INSERT INTO users (username, email, password, first_name, last_name, age, gender, created_at)
+		VALUES (?, ?, 'x', 'Test', 'User', 30, 'other', ?)`,
+		name, name+"@example.com", time.Now().Add(-48*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	return int(id)
+}
@@ -1,24 +1,136 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"real/api"
+	"real/auth"
+	"real/config"
 	"real/db"
 	"real/handlers"
+	"real/ratelimit"
+	"real/realtime"
+	"real/storage"
+	"real/uploads"
 )
 
 func main() {
+	if err := config.Load("config.json"); err != nil {
+		log.Fatalf("Loading config failed: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate-media" {
+		if err := migrateMedia(os.Args[2:]); err != nil {
+			log.Fatalf("Migrating media failed: %v", err)
+		}
+		return
+	}
+
+	media, err := storage.New(config.Current.Storage)
+	if err != nil {
+		log.Fatalf("Opening media storage failed: %v", err)
+	}
+	storage.Use(media)
+
 	// Initialize database
 	if err := db.Init("./forum.db"); err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
 	defer db.DB.Close()
 
+	// Post images from before media storage have to be in place before the
+	// sweeper runs and before their posts are served.
+	if err := uploads.ImportLegacyPostImages(uploads.LegacyPostImagesDir); err != nil {
+		log.Fatalf("Copying post images into media storage failed: %v", err)
+	}
+
 	// Set up API routes
 	http.HandleFunc("/api/categories", handlers.GetCategoriesHandler)
-	http.HandleFunc("/post/create", handlers.CreatePostHandler)
+	http.HandleFunc("GET /api/search", handlers.SearchHandler)
+	http.HandleFunc("GET /api/posts", handlers.ListPostsHandler)
+	http.HandleFunc("GET /api/posts/{id}/comments", handlers.ListCommentsHandler)
+	http.Handle("POST /api/posts/{id}/comments", ratelimit.Middleware(ratelimit.ActionComment, http.HandlerFunc(handlers.CreateCommentHandler)))
+	http.HandleFunc("DELETE /api/comments/{id}", handlers.DeleteCommentHandler)
+	http.HandleFunc("POST /api/posts/{id}/attachments", handlers.AddPostAttachmentHandler)
+	http.HandleFunc("PATCH /api/posts/{id}/attachments/{attachmentID}", handlers.UpdatePostAttachmentHandler)
+	http.HandleFunc("DELETE /api/posts/{id}/attachments/{attachmentID}", handlers.DeletePostAttachmentHandler)
+	http.Handle("POST /api/posts/{id}/vote", ratelimit.Middleware(ratelimit.ActionVote, http.HandlerFunc(handlers.VotePostHandler)))
+	http.Handle("POST /api/comments/{id}/vote", ratelimit.Middleware(ratelimit.ActionVote, http.HandlerFunc(handlers.VoteCommentHandler)))
+	http.HandleFunc("GET /api/notifications", handlers.ListNotificationsHandler)
+	http.HandleFunc("GET /api/notifications/unread-count", handlers.UnreadNotificationsHandler)
+	http.HandleFunc("POST /api/notifications/{id}/read", handlers.MarkNotificationReadHandler)
+	http.HandleFunc("POST /api/notifications/read-all", handlers.MarkAllNotificationsReadHandler)
+	http.HandleFunc("GET /api/users", handlers.ListChatUsersHandler)
+	http.HandleFunc("GET /api/conversations", handlers.ListConversationsHandler)
+	http.HandleFunc("POST /api/conversations", handlers.CreateGroupHandler)
+	http.HandleFunc("POST /api/conversations/direct", handlers.OpenDirectConversationHandler)
+	http.HandleFunc("GET /api/conversations/{id}", handlers.GetConversationHandler)
+	http.HandleFunc("PATCH /api/conversations/{id}", handlers.RenameConversationHandler)
+	http.HandleFunc("POST /api/conversations/{id}/members", handlers.AddMembersHandler)
+	http.HandleFunc("DELETE /api/conversations/{id}/members/{userID}", handlers.RemoveMemberHandler)
+	http.HandleFunc("POST /api/conversations/{id}/leave", handlers.LeaveConversationHandler)
+	http.HandleFunc("GET /api/conversations/{id}/messages", handlers.ListMessagesHandler)
+	http.Handle("POST /api/conversations/{id}/messages", ratelimit.Middleware(ratelimit.ActionMessage, http.HandlerFunc(handlers.SendMessageHandler)))
+	http.HandleFunc("POST /api/conversations/{id}/read", handlers.MarkConversationReadHandler)
+	http.HandleFunc("POST /api/attachments", handlers.UploadAttachmentHandler)
+	http.HandleFunc("GET /api/attachments/{id}", handlers.ServeAttachmentHandler)
+	http.HandleFunc("GET /media/{key...}", handlers.MediaHandler)
+	http.HandleFunc("OPTIONS /api/uploads", handlers.UploadOptionsHandler)
+	http.HandleFunc("POST /api/uploads", handlers.CreateUploadHandler)
+	http.HandleFunc("HEAD /api/uploads/{id}", handlers.UploadOffsetHandler)
+	http.HandleFunc("GET /api/uploads/{id}", handlers.GetUploadHandler)
+	http.HandleFunc("PATCH /api/uploads/{id}", handlers.AppendUploadHandler)
+	http.HandleFunc("DELETE /api/uploads/{id}", handlers.DeleteUploadHandler)
+	http.HandleFunc("PATCH /api/messages/{id}", handlers.EditMessageHandler)
+	http.HandleFunc("DELETE /api/messages/{id}", handlers.DeleteMessageHandler)
+	http.HandleFunc("GET /api/me/blocks", handlers.ListBlocksHandler)
+	http.HandleFunc("POST /api/users/{id}/block", handlers.BlockUserHandler)
+	http.HandleFunc("DELETE /api/users/{id}/block", handlers.UnblockUserHandler)
+	http.HandleFunc("GET /api/me/mutes", handlers.ListMutesHandler)
+	http.HandleFunc("POST /api/users/{id}/mute", handlers.MuteUserHandler)
+	http.HandleFunc("DELETE /api/users/{id}/mute", handlers.UnmuteUserHandler)
+	http.HandleFunc("POST /api/reports", handlers.CreateReportHandler)
+	http.HandleFunc("GET /api/me/settings", handlers.GetSettingsHandler)
+	http.HandleFunc("PATCH /api/me/settings", handlers.UpdateSettingsHandler)
+
+	// Real-time events
+	if config.Current.Realtime.Broker == "sqlite" {
+		broker, err := realtime.NewSQLiteBroker(time.Duration(config.Current.Realtime.PollIntervalMs) * time.Millisecond)
+		if err != nil {
+			log.Fatalf("Starting real-time broker failed: %v", err)
+		}
+		realtime.UseBroker(broker)
+	}
+	http.HandleFunc("GET /ws", realtime.ServeWS)
+	http.HandleFunc("GET /api/events", realtime.ServeSSE)
+	realtime.HandleLimitedFunc("send_message", ratelimit.ActionMessage, handlers.SocketSendMessage)
+	go db.ScheduleEventPruning(time.Hour, time.Duration(config.Current.Realtime.ReplayWindowHours)*time.Hour)
+	go uploads.SchedulePostImageSweep(time.Hour, time.Hour)
+	go uploads.ScheduleResumableSweep(time.Hour)
+
+	// Admin routes
+	http.Handle("GET /api/admin/categories", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.AdminListCategoriesHandler)))
+	http.Handle("POST /api/admin/categories", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.CreateCategoryHandler)))
+	http.Handle("PATCH /api/admin/categories/{id}", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.UpdateCategoryHandler)))
+	http.Handle("DELETE /api/admin/categories/{id}", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.DeleteCategoryHandler)))
+	http.Handle("GET /api/admin/filter-rules", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.ListFilterRulesHandler)))
+	http.Handle("POST /api/admin/filter-rules", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.CreateFilterRuleHandler)))
+	http.Handle("PATCH /api/admin/filter-rules/{id}", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.UpdateFilterRuleHandler)))
+	http.Handle("DELETE /api/admin/filter-rules/{id}", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(handlers.DeleteFilterRuleHandler)))
+	http.Handle("GET /api/moderation/cases", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.ListCasesHandler)))
+	http.Handle("GET /api/moderation/cases/{id}", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.GetCaseHandler)))
+	http.Handle("POST /api/moderation/cases/{id}/actions", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.CaseActionHandler)))
+	http.Handle("GET /api/moderation/users/{id}/sanctions", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.UserSanctionsHandler)))
+	http.Handle("POST /api/moderation/users/{id}/sanctions", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.SanctionUserHandler)))
+	http.Handle("POST /api/moderation/sanctions/{id}/lift", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.LiftSanctionHandler)))
+	http.Handle("GET /api/moderation/log", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.ModerationLogHandler)))
+	http.Handle("GET /api/moderation/messages/{id}/edits", auth.RequireRole(auth.RoleModerator, http.HandlerFunc(handlers.MessageEditsHandler)))
+	http.Handle("GET /api/admin/realtime/metrics", auth.RequireRole(auth.RoleAdmin, http.HandlerFunc(realtime.MetricsHandler)))
+
+	http.Handle("/post/create", ratelimit.Middleware(ratelimit.ActionPost, http.HandlerFunc(handlers.CreatePostHandler)))
 	http.HandleFunc("/login", handlers.LoginHandler)
 	http.HandleFunc("/register", recoverMiddleware(handlers.RegisterHandler))
 	// Serve static files
@@ -40,30 +152,18 @@ func main() {
 	})
 
 	// Start server
-	log.Println("Server started at http://localhost:8081")
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	log.Printf("Server started at %s", config.Current.Addr)
+	log.Fatal(http.ListenAndServe(config.Current.Addr, api.RequestIDMiddleware(auth.SessionMiddleware(http.DefaultServeMux))))
 }
 
 func recoverMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
-				writeJSON(w, http.StatusInternalServerError, map[string]string{
-					"status":  "error",
-					"message": "Internal server error",
-				})
+				log.Printf("Panic recovered in request %s: %v", api.RequestID(r), err)
+				api.WriteError(w, api.Internal())
 			}
 		}()
 		next(w, r)
 	}
 }
-
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		log.Printf("JSON encode error: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-	}
-}
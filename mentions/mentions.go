@@ -0,0 +1,135 @@
+package mentions
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"real/db"
+	"real/models"
+	"real/notifications"
+)
+
+// Content types a mention can appear in.
+const (
+	ContentPost    = "post"
+	ContentComment = "comment"
+	ContentMessage = "message"
+)
+
+// mentionPattern matches @username when the @ starts a word, so e-mail
+// addresses are not picked up. Group 2 is the username.
+var mentionPattern = regexp.MustCompile(`(^|[^\w@])@([\w.-]+)`)
+
+type match struct {
+	start, end int // byte range of "@username" in the text
+	username   string
+}
+
+func find(text string) []match {
+	var matches []match
+	for _, idx := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// Trailing dots and hyphens are sentence punctuation, not part of the
+		// name: "thanks @bob." mentions bob.
+		name := strings.TrimRight(text[idx[4]:idx[5]], ".-")
+		if name == "" {
+			continue
+		}
+		matches = append(matches, match{
+			start:    idx[4] - 1,
+			end:      idx[4] + len(name),
+			username: name,
+		})
+	}
+	return matches
+}
+
+// Extract returns the distinct usernames mentioned in text, in order of
+// first appearance.
+func Extract(text string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, m := range find(text) {
+		if !seen[m.username] {
+			seen[m.username] = true
+			names = append(names, m.username)
+		}
+	}
+	return names
+}
+
+// Record stores the mentions in a piece of content that was just saved and
+// notifies each mentioned user. Saving the same content again, e.g. after an
+// edit, only notifies users who were not mentioned before. Mentions of users
+// who have blocked the author, and in messages of users outside the
+// conversation, are dropped without telling the author.
+func Record(contentType string, contentID, authorID int, text string) error {
+	ids, err := db.GetUserIDsByUsername(Extract(text))
+	if err != nil {
+		return fmt.Errorf("resolving mentions: %w", err)
+	}
+
+	for _, userID := range ids {
+		if userID == authorID {
+			continue
+		}
+
+		// A private message must not tell outsiders that it exists.
+		if contentType == ContentMessage {
+			member, err := db.CanReadMessage(int64(contentID), userID)
+			if err != nil {
+				return fmt.Errorf("checking message access: %w", err)
+			}
+			if !member {
+				continue
+			}
+		}
+
+		blocked, err := db.IsBlocked(userID, authorID)
+		if err != nil {
+			return fmt.Errorf("checking block: %w", err)
+		}
+		if blocked {
+			continue
+		}
+
+		added, err := db.AddMention(contentType, contentID, userID, authorID)
+		if err != nil {
+			return fmt.Errorf("saving mention: %w", err)
+		}
+		if !added {
+			continue
+		}
+
+		if err := notifications.Notify(userID, authorID, notifications.KindMention, contentType, contentID); err != nil {
+			return fmt.Errorf("notifying mention: %w", err)
+		}
+	}
+	return nil
+}
+
+// Render escapes text for HTML and turns every recorded mention into a link
+// to the mentioned user's profile. @names that were not recorded as mentions
+// stay plain text.
+func Render(text string, recorded []models.Mention) string {
+	linked := make(map[string]bool, len(recorded))
+	for _, m := range recorded {
+		linked[m.Username] = true
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range find(text) {
+		if !linked[m.username] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[last:m.start]))
+		fmt.Fprintf(&b, `<a class="mention" href="/profile/%s">@%s</a>`,
+			html.EscapeString(url.PathEscape(m.username)), html.EscapeString(m.username))
+		last = m.end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"real/config"
+	"real/storage"
+)
+
+// migrateMedia copies uploaded media from one storage backend to another:
+//
+//	forum migrate-media -from local -to s3
+//
+// Both backends are set up from config.json whichever one it selects.
+// -from-root reads from another local directory instead of
+// storage.local.root. Objects already at the target with the same size are
+// skipped, so an interrupted run can be repeated. Nothing is deleted from
+// the source; switch storage.backend once the copy is done.
+func migrateMedia(args []string) error {
+	flags := flag.NewFlagSet("migrate-media", flag.ContinueOnError)
+	from := flags.String("from", "local", `backend to copy from, "local" or "s3"`)
+	to := flags.String("to", "s3", `backend to copy to, "local" or "s3"`)
+	fromRoot := flags.String("from-root", "", "directory to copy from when -from is local")
+	prefix := flags.String("prefix", "", "only copy keys starting with this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	source := config.Current.Storage
+	source.Backend = *from
+	if *fromRoot != "" {
+		source.Local.Root = *fromRoot
+	}
+	target := config.Current.Storage
+	target.Backend = *to
+	if source.Backend == target.Backend && (source.Backend != "local" || source.Local.Root == target.Local.Root) {
+		return fmt.Errorf("source and target are the same")
+	}
+
+	src, err := storage.New(source)
+	if err != nil {
+		return err
+	}
+	dst, err := storage.New(target)
+	if err != nil {
+		return err
+	}
+
+	n, err := storage.Copy(src, dst, *prefix, func(key string) {
+		log.Printf("Copied %s", key)
+	})
+	log.Printf("Copied %d objects", n)
+	return err
+}
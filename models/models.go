@@ -3,17 +3,292 @@ package models
 import "time"
 
 type Category struct {
-    CategoryID  int    `json:"category_id"`
-    Name        string `json:"name"`
-    Description string `json:"description"`
+    CategoryID   int        `json:"category_id"`
+    Name         string     `json:"name"`
+    Slug         string     `json:"slug"`
+    Description  string     `json:"description"`
+    SortOrder    int        `json:"sort_order"`
+    Archived     bool       `json:"archived"`
+    PostCount    int        `json:"post_count"`
+    LastActivity *time.Time `json:"last_activity"`
 }
 
 type Post struct {
     PostID    int       `json:"post_id"`
     UserID    int       `json:"user_id"`
+    Username  string    `json:"username"`
     Title     string    `json:"title"`
     Content   string    `json:"content"`
-    ImageURL  string    `json:"image_url"`
+    // ImageURL is the first attachment's original, for clients that only
+    // show one image.
+    ImageURL    string           `json:"image_url"`
+    Attachments []PostAttachment `json:"attachments"`
+    CreatedAt   time.Time        `json:"created_at"`
+    UpdatedAt   time.Time        `json:"updated_at"`
+    // Held is set while the post waits for a moderator's review.
+    Held bool `json:"held,omitempty"`
+
+    // ContentHTML is Content escaped for HTML with mentions linked.
+    ContentHTML string `json:"content_html"`
+}
+
+// PostAttachment is an image attached to a post. Variants holds every size
+// it is stored in, keyed by "original", "medium" and "thumbnail"; for an
+// image already smaller than a size, that variant is the original. Images
+// from before posts had attachments only have "original".
+type PostAttachment struct {
+    AttachmentID int64                   `json:"attachment_id"`
+    PostID       int                     `json:"post_id"`
+    Position     int                     `json:"position"`
+    AltText      string                  `json:"alt_text"`
+    ContentType  string                  `json:"content_type"`
+    Variants     map[string]ImageVariant `json:"variants"`
+    CreatedAt    time.Time               `json:"created_at"`
+}
+
+// ImageVariant is one stored size of an image. Width, height and size are 0
+// for images uploaded before they were recorded.
+type ImageVariant struct {
+    URL    string `json:"url"`
+    Width  int    `json:"width"`
+    Height int    `json:"height"`
+    Size   int64  `json:"size"`
+
+    Key string `json:"-"`
+}
+
+type SearchResult struct {
+    Kind      string    `json:"kind"`
+    ID        int       `json:"id"`
+    PostID    int       `json:"post_id"`
+    Title     string    `json:"title"`
+    Snippet   string    `json:"snippet"`
+    Rank      float64   `json:"rank"`
+    Author    string    `json:"author"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+type Comment struct {
+    CommentID int        `json:"comment_id"`
+    PostID    int        `json:"post_id"`
+    UserID    int        `json:"user_id"`
+    Username  string     `json:"username"`
+    ParentID  *int       `json:"parent_comment_id"`
+    Depth     int        `json:"depth"`
+    Content   string     `json:"content"`
+    Deleted   bool       `json:"deleted"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    Replies   []*Comment `json:"replies,omitempty"`
+    // Held is set while the comment waits for a moderator's review.
+    Held bool `json:"held,omitempty"`
+
+    // ContentHTML is Content escaped for HTML with mentions linked.
+    ContentHTML string `json:"content_html"`
+}
+
+type Mention struct {
+    UserID   int    `json:"user_id"`
+    Username string `json:"username"`
+}
+
+type Notification struct {
+    NotificationID int64      `json:"notification_id"`
+    UserID         int        `json:"user_id"`
+    ActorID        int        `json:"actor_id"`
+    ActorUsername  string     `json:"actor_username"`
+    Kind           string     `json:"kind"`
+    TargetType     string     `json:"target_type"`
+    TargetID       int        `json:"target_id"`
+    CreatedAt      time.Time  `json:"created_at"`
+    ReadAt         *time.Time `json:"read_at"`
+}
+
+type VoteSummary struct {
+    Likes    int    `json:"likes"`
+    Dislikes int    `json:"dislikes"`
+    MyVote   string `json:"my_vote"`
+}
+
+type Message struct {
+    MessageID      int64     `json:"message_id"`
+    ConversationID int       `json:"conversation_id"`
+    SenderID       int       `json:"sender_id"`
+    SenderUsername string    `json:"sender_username"`
+    Kind           string    `json:"kind"`
+    ClientMsgID    string    `json:"client_msg_id,omitempty"`
+    Content        string    `json:"content"`
+    CreatedAt      time.Time `json:"created_at"`
+    EditedAt       *time.Time `json:"edited_at"`
+    // A deleted message keeps its place in the history with empty content.
+    DeletedAt *time.Time `json:"deleted_at"`
+    // Held is set while the message waits for a moderator's review.
+    Held bool `json:"held,omitempty"`
+
+    Attachments []Attachment `json:"attachments"`
+
+    // Seen is set on the reader's own messages once another member has read
+    // them, unless that member turned read receipts off.
+    Seen bool `json:"seen"`
+
+    // ContentHTML is Content escaped for HTML with mentions linked.
+    ContentHTML string `json:"content_html"`
+}
+
+// Attachment is a file sent with a message. It is only served to members of
+// the conversation, at URL.
+type Attachment struct {
+    AttachmentID int64     `json:"attachment_id"`
+    Filename     string    `json:"filename"`
+    ContentType  string    `json:"content_type"`
+    Size         int64     `json:"size"`
+    URL          string    `json:"url"`
+    CreatedAt    time.Time `json:"created_at"`
+
+    UploaderID int    `json:"-"`
+    MessageID  *int64 `json:"-"`
+    StoredName string `json:"-"`
+}
+
+// Upload is a resumable upload, received in parts until Received reaches
+// Size.
+type Upload struct {
+    UploadID  string    `json:"upload_id"`
+    Purpose   string    `json:"purpose"`
+    Filename  string    `json:"filename"`
+    Size      int64     `json:"size"`
+    Received  int64     `json:"received"`
+    CreatedAt time.Time `json:"created_at"`
+    ExpiresAt time.Time `json:"expires_at"`
+
+    UserID       int    `json:"-"`
+    AttachmentID *int64 `json:"-"`
+}
+
+// BlockedUser is an entry in a user's block or mute list.
+type BlockedUser struct {
+    UserID    int       `json:"user_id"`
+    Username  string    `json:"username"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// MessageEdit is what a message said before it was edited or deleted.
+type MessageEdit struct {
+    EditID     int64     `json:"edit_id"`
+    Content    string    `json:"content"`
+    ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type ConversationMember struct {
+    UserID   int    `json:"user_id"`
+    Username string `json:"username"`
+}
+
+type Conversation struct {
+    ConversationID int                  `json:"conversation_id"`
+    Kind           string               `json:"kind"`
+    Title          string               `json:"title,omitempty"`
+    OwnerID        int                  `json:"owner_id,omitempty"`
+    Members        []ConversationMember `json:"members"`
+
+    // Only filled in when listing a user's conversations.
+    LastMessage *Message `json:"last_message,omitempty"`
+    UnreadCount int      `json:"unread_count"`
+}
+
+// ChatUser is an entry in the chat sidebar's user list.
+type ChatUser struct {
+    UserID         int        `json:"user_id"`
+    Username       string     `json:"username"`
+    Online         bool       `json:"online"`
+    ConversationID int        `json:"conversation_id,omitempty"`
+    LastMessageAt  *time.Time `json:"last_message_at"`
+    UnreadCount    int        `json:"unread_count"`
+}
+
+type UserSettings struct {
+    ReadReceipts bool `json:"read_receipts"`
+}
+
+// StoredEvent is a real-time event as kept in the events table. Payload is
+// the JSON encoded event data; UserID is 0 for events sent to everyone.
+type StoredEvent struct {
+    EventID   int64
+    UserID    int
+    Type      string
+    Payload   []byte
+    Ephemeral bool
+}
+
+// ModerationCase groups the reports about one post, comment, message or
+// user while moderators look into it.
+type ModerationCase struct {
+    CaseID         int64      `json:"case_id"`
+    TargetType     string     `json:"target_type"`
+    TargetID       int        `json:"target_id"`
+    TargetUserID   *int       `json:"target_user_id"`
+    TargetUsername string     `json:"target_username"`
+    Status         string     `json:"status"`
+    Resolution     string     `json:"resolution,omitempty"`
+    ResolvedBy     *int       `json:"resolved_by"`
+    ResolvedAt     *time.Time `json:"resolved_at"`
+    CreatedAt      time.Time  `json:"created_at"`
+    ReportCount    int        `json:"report_count"`
+    LastReportedAt time.Time  `json:"last_reported_at"`
+
+    // Only filled in when fetching a single case.
+    Reports []Report    `json:"reports,omitempty"`
+    Target  interface{} `json:"target,omitempty"`
+}
+
+type Report struct {
+    ReportID         int64     `json:"report_id"`
+    CaseID           int64     `json:"case_id"`
+    // ReporterID is nil for content the content filter flagged.
+    ReporterID       *int      `json:"reporter_id"`
+    ReporterUsername string    `json:"reporter_username"`
+    Reason           string    `json:"reason"`
+    Details          string    `json:"details"`
+    CreatedAt        time.Time `json:"created_at"`
+}
+
+// ModerationLogEntry records one action a moderator took.
+type ModerationLogEntry struct {
+    LogID             int64     `json:"log_id"`
+    ModeratorID       int       `json:"moderator_id"`
+    ModeratorUsername string    `json:"moderator_username"`
+    Action            string    `json:"action"`
+    TargetType        string    `json:"target_type"`
+    TargetID          int       `json:"target_id"`
+    TargetUserID      *int      `json:"target_user_id"`
+    CaseID            *int64    `json:"case_id"`
+    Note              string    `json:"note"`
+    CreatedAt         time.Time `json:"created_at"`
+}
+
+// Sanction keeps a user from signing in: a suspension until it expires, a
+// ban for good. Either can be lifted early.
+type Sanction struct {
+    SanctionID int64      `json:"sanction_id"`
+    UserID     int        `json:"user_id"`
+    Kind       string     `json:"kind"`
+    Reason     string     `json:"reason"`
+    IssuedBy   int        `json:"issued_by"`
+    ExpiresAt  *time.Time `json:"expires_at"`
+    LiftedBy   *int       `json:"lifted_by"`
+    LiftedAt   *time.Time `json:"lifted_at"`
+    CreatedAt  time.Time  `json:"created_at"`
+}
+
+// FilterRule makes the content filter act on text containing a word or
+// matching a regular expression.
+type FilterRule struct {
+    RuleID    int       `json:"rule_id"`
+    Kind      string    `json:"kind"`
+    Pattern   string    `json:"pattern"`
+    Action    string    `json:"action"`
+    Note      string    `json:"note"`
+    CreatedBy *int      `json:"created_by"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,461 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"real/auth"
+	"real/chat"
+	"real/db"
+	"real/models"
+	"real/notifications"
+	"real/realtime"
+)
+
+// What can be reported.
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+	TargetMessage = "message"
+	TargetUser    = "user"
+)
+
+// Reasons a report can give.
+var Reasons = []string{"spam", "harassment", "hate", "sexual", "violence", "other"}
+
+// Actions a moderator can take on a case. Each one resolves it.
+const (
+	ActionDismiss = "dismiss"
+	ActionRemove  = "remove"
+	ActionWarn    = "warn"
+	ActionSuspend = "suspend"
+	ActionBan     = "ban"
+	// ActionLift is logged when a moderator ends a sanction early.
+	ActionLift = "lift"
+)
+
+const (
+	MaxDetailsLength = 1000
+	MaxNoteLength    = 1000
+	// DefaultSuspendDays is how long a suspension lasts unless the
+	// moderator says otherwise.
+	DefaultSuspendDays = 7
+	MaxSuspendDays     = 365
+)
+
+var (
+	ErrInvalidTarget   = errors.New("invalid target type")
+	ErrTargetNotFound  = errors.New("target not found")
+	ErrOwnContent      = errors.New("cannot report yourself")
+	ErrInvalidReason   = errors.New("invalid reason")
+	ErrDetailsTooLong  = errors.New("details are too long")
+	ErrInvalidAction   = errors.New("invalid action")
+	ErrNoteTooLong     = errors.New("note is too long")
+	ErrInvalidDuration = errors.New("invalid suspension length")
+	ErrCaseNotFound    = errors.New("case not found")
+	ErrCaseResolved    = errors.New("case already resolved")
+	// ErrNoTargetUser means the action needs a user to act on, and the
+	// target has none, e.g. because the account was deleted.
+	ErrNoTargetUser = errors.New("target has no user")
+	// ErrProtectedUser means the target user is a moderator or admin.
+	ErrProtectedUser    = errors.New("cannot sanction staff")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrSanctionNotFound = errors.New("sanction not found")
+	ErrSanctionInactive = errors.New("sanction no longer in force")
+)
+
+// Report files a report by reporterID about a post, comment, message or
+// user. Reports about the same target are grouped into one case. Users can
+// only report messages in their own conversations. The returned bool is
+// false when the user had already reported the target in its open case.
+func Report(reporterID int, targetType string, targetID int, reason, details string) (models.Report, bool, error) {
+	if !validReason(reason) {
+		return models.Report{}, false, ErrInvalidReason
+	}
+	details = strings.TrimSpace(details)
+	if utf8.RuneCountInString(details) > MaxDetailsLength {
+		return models.Report{}, false, ErrDetailsTooLong
+	}
+
+	authorID, err := reportableAuthor(reporterID, targetType, targetID)
+	if err != nil {
+		return models.Report{}, false, err
+	}
+	if authorID == reporterID {
+		return models.Report{}, false, ErrOwnContent
+	}
+
+	return db.CreateReport(targetType, targetID, &authorID, reporterID, reason, details)
+}
+
+// reportableAuthor returns who is responsible for a target the reporter can
+// see.
+func reportableAuthor(reporterID int, targetType string, targetID int) (int, error) {
+	switch targetType {
+	case TargetPost:
+		post, err := db.GetPost(targetID)
+		if errors.Is(err, db.ErrPostNotFound) {
+			return 0, ErrTargetNotFound
+		}
+		return post.UserID, err
+
+	case TargetComment:
+		comment, err := db.GetComment(targetID)
+		if errors.Is(err, db.ErrCommentNotFound) || (err == nil && comment.Deleted) {
+			return 0, ErrTargetNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+		return comment.UserID, nil
+
+	case TargetMessage:
+		// Outsiders must not learn whether a private message exists.
+		member, err := db.CanReadMessage(int64(targetID), reporterID)
+		if err != nil {
+			return 0, err
+		}
+		if !member {
+			return 0, ErrTargetNotFound
+		}
+		msg, err := db.GetMessage(int64(targetID))
+		if err != nil {
+			return 0, err
+		}
+		if msg.DeletedAt != nil || msg.Kind != db.MessageUser {
+			return 0, ErrTargetNotFound
+		}
+		return msg.SenderID, nil
+
+	case TargetUser:
+		exists, err := db.UserExists(targetID)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrTargetNotFound
+		}
+		return targetID, nil
+	}
+	return 0, ErrInvalidTarget
+}
+
+func validReason(reason string) bool {
+	for _, r := range Reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Case returns a case with its reports and the reported content as it is
+// now. A reported message comes with every earlier version of it. Target is
+// left out once the content is gone.
+func Case(caseID int64) (models.ModerationCase, error) {
+	c, err := db.GetCase(caseID)
+	if errors.Is(err, db.ErrCaseNotFound) {
+		return c, ErrCaseNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+
+	target, err := loadTarget(c.TargetType, c.TargetID)
+	if err != nil {
+		return c, err
+	}
+	if target != nil {
+		c.Target = target
+	}
+	return c, nil
+}
+
+func loadTarget(targetType string, targetID int) (interface{}, error) {
+	switch targetType {
+	case TargetPost:
+		post, err := db.GetPost(targetID)
+		if errors.Is(err, db.ErrPostNotFound) {
+			return nil, nil
+		}
+		return post, err
+
+	case TargetComment:
+		comment, err := db.GetComment(targetID)
+		if errors.Is(err, db.ErrCommentNotFound) {
+			return nil, nil
+		}
+		return comment, err
+
+	case TargetMessage:
+		msg, err := db.GetMessage(int64(targetID))
+		if errors.Is(err, db.ErrMessageNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		edits, err := db.ListMessageEdits(msg.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"message": msg, "edits": edits}, nil
+
+	case TargetUser:
+		profile, err := db.GetUser(targetID)
+		if err != nil {
+			return nil, nil
+		}
+		return map[string]interface{}{
+			"user_id":         targetID,
+			"username":        profile[0],
+			"bio":             profile[1],
+			"profile_picture": profile[2],
+		}, nil
+	}
+	return nil, nil
+}
+
+// Action is what a moderator decided to do about a case.
+type Action struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+	// Days is how long a suspension lasts; 0 means DefaultSuspendDays.
+	Days int `json:"days"`
+}
+
+// Act carries out a moderator's action on an open case and resolves it:
+// dismiss closes it, publishing content the filter held or hid, remove
+// takes the content down (for a user, their profile text and picture), warn
+// notifies the user responsible, suspend keeps them from signing in for a
+// number of days and ban for good. Every action is written to the
+// moderation log.
+func Act(moderatorID int, caseID int64, a Action) error {
+	a.Note = strings.TrimSpace(a.Note)
+	if utf8.RuneCountInString(a.Note) > MaxNoteLength {
+		return ErrNoteTooLong
+	}
+
+	switch a.Action {
+	case ActionDismiss, ActionRemove, ActionWarn, ActionSuspend, ActionBan:
+	default:
+		return ErrInvalidAction
+	}
+
+	c, err := db.GetCase(caseID)
+	if errors.Is(err, db.ErrCaseNotFound) {
+		return ErrCaseNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// The case is claimed before acting, so moderators acting on it at
+	// once cannot both carry out their action. The action itself writes
+	// through other connections and pushes live events, so it cannot share
+	// the claim's transaction; if it fails, the case is reopened instead.
+	logID, err := db.ResolveCase(caseID, moderatorID, a.Action, a.Note)
+	if errors.Is(err, db.ErrCaseNotFound) {
+		return ErrCaseNotFound
+	}
+	if errors.Is(err, db.ErrCaseResolved) {
+		return ErrCaseResolved
+	}
+	if err != nil {
+		return err
+	}
+
+	switch a.Action {
+	case ActionDismiss:
+		err = publish(c.TargetType, c.TargetID)
+	case ActionRemove:
+		err = remove(c.TargetType, c.TargetID)
+	case ActionWarn:
+		err = warn(moderatorID, c)
+	case ActionSuspend, ActionBan:
+		err = sanctionCase(moderatorID, c, a)
+	}
+	if err != nil {
+		if reopenErr := db.ReopenCase(caseID, logID); reopenErr != nil {
+			log.Printf("Error reopening case %d: %v", caseID, reopenErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// publish lets through content the filter held or hid. Mentions and
+// notifications it would have sent are not sent late.
+func publish(targetType string, targetID int) error {
+	switch targetType {
+	case TargetPost:
+		published, err := db.Publish(targetType, targetID)
+		if err != nil || !published {
+			return err
+		}
+		post, err := db.GetPost(targetID)
+		if err != nil {
+			return err
+		}
+		realtime.Broadcast(realtime.EventPostCreated, map[string]interface{}{
+			"post_id": post.PostID,
+			"user_id": post.UserID,
+			"title":   post.Title,
+		})
+	case TargetComment:
+		_, err := db.Publish(targetType, targetID)
+		return err
+	case TargetMessage:
+		err := chat.Publish(int64(targetID))
+		if errors.Is(err, chat.ErrMessageNotFound) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func remove(targetType string, targetID int) error {
+	var err error
+	switch targetType {
+	case TargetPost:
+		err = db.DeletePost(targetID)
+	case TargetComment:
+		err = db.DeleteComment(targetID)
+	case TargetMessage:
+		_, err = chat.Remove(int64(targetID))
+	case TargetUser:
+		err = db.ClearProfile(targetID)
+	}
+	// Content its author already deleted needs nothing more.
+	if errors.Is(err, db.ErrPostNotFound) || errors.Is(err, db.ErrCommentNotFound) || errors.Is(err, chat.ErrMessageNotFound) {
+		return nil
+	}
+	return err
+}
+
+func warn(moderatorID int, c models.ModerationCase) error {
+	userID, err := sanctionable(c)
+	if err != nil {
+		return err
+	}
+	return notifications.Notify(userID, moderatorID, notifications.KindWarning, c.TargetType, c.TargetID)
+}
+
+func sanctionCase(moderatorID int, c models.ModerationCase, a Action) error {
+	userID, err := sanctionable(c)
+	if err != nil {
+		return err
+	}
+
+	reason := a.Note
+	if reason == "" {
+		reason = fmt.Sprintf("Reported %s %d", c.TargetType, c.TargetID)
+	}
+	_, err = sanction(moderatorID, userID, a.Action, a.Days, reason)
+	return err
+}
+
+// Sanction suspends a user for a number of days (0 for DefaultSuspendDays)
+// when action is suspend, or bans them for good when it is ban. They are
+// signed out at once, live connections included, and it is written to the
+// moderation log.
+func Sanction(moderatorID, userID int, action string, days int, reason string) (models.Sanction, error) {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > MaxNoteLength {
+		return models.Sanction{}, ErrNoteTooLong
+	}
+
+	exists, err := db.UserExists(userID)
+	if err != nil {
+		return models.Sanction{}, err
+	}
+	if !exists {
+		return models.Sanction{}, ErrUserNotFound
+	}
+	if isStaff(userID) {
+		return models.Sanction{}, ErrProtectedUser
+	}
+
+	s, err := sanction(moderatorID, userID, action, days, reason)
+	if err != nil {
+		return s, err
+	}
+	if err := db.LogAction(moderatorID, action, TargetUser, userID, &userID, reason); err != nil {
+		log.Printf("Error logging %s of user %d: %v", action, userID, err)
+	}
+	return s, nil
+}
+
+func sanction(moderatorID, userID int, action string, days int, reason string) (models.Sanction, error) {
+	var (
+		kind      string
+		expiresAt *time.Time
+	)
+	switch action {
+	case ActionSuspend:
+		if days == 0 {
+			days = DefaultSuspendDays
+		}
+		if days < 0 || days > MaxSuspendDays {
+			return models.Sanction{}, ErrInvalidDuration
+		}
+		t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		kind, expiresAt = db.SanctionSuspension, &t
+	case ActionBan:
+		kind = db.SanctionBan
+	default:
+		return models.Sanction{}, ErrInvalidAction
+	}
+
+	s, err := db.CreateSanction(userID, moderatorID, kind, reason, expiresAt)
+	if err != nil {
+		return s, err
+	}
+	realtime.SignOut(s)
+	log.Printf("User %d given a %s by moderator %d", userID, kind, moderatorID)
+	return s, nil
+}
+
+// Lift ends a suspension or ban early.
+func Lift(moderatorID int, sanctionID int64, note string) (models.Sanction, error) {
+	note = strings.TrimSpace(note)
+	if utf8.RuneCountInString(note) > MaxNoteLength {
+		return models.Sanction{}, ErrNoteTooLong
+	}
+
+	s, err := db.LiftSanction(sanctionID, moderatorID)
+	switch {
+	case errors.Is(err, db.ErrSanctionNotFound):
+		return s, ErrSanctionNotFound
+	case errors.Is(err, db.ErrSanctionInactive):
+		return s, ErrSanctionInactive
+	case err != nil:
+		return s, err
+	}
+	if err := db.LogAction(moderatorID, ActionLift, TargetUser, s.UserID, &s.UserID, note); err != nil {
+		log.Printf("Error logging lift of sanction %d: %v", sanctionID, err)
+	}
+	return s, nil
+}
+
+// sanctionable returns the user responsible for a case's target, unless
+// they are staff.
+func sanctionable(c models.ModerationCase) (int, error) {
+	if c.TargetUserID == nil {
+		return 0, ErrNoTargetUser
+	}
+	if isStaff(*c.TargetUserID) {
+		return 0, ErrProtectedUser
+	}
+	return *c.TargetUserID, nil
+}
+
+func isStaff(userID int) bool {
+	return auth.HasRole(strconv.Itoa(userID), auth.RoleModerator)
+}
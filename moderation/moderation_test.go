@@ -0,0 +1,110 @@
+//go:build sqlite_fts5
+
+package moderation
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"real/db"
+	"real/internal/testdb"
+)
+
+// reportedPost files a report about a new post by authorID and returns the
+// case it opened.
+func reportedPost(t *testing.T, authorID, reporterID int) int64 {
+	t.Helper()
+	result, err := db.DB.Exec(
+		`INSERT INTO posts (user_id, title, content) VALUES (?, 'Reported', 'Something reportable')`, authorID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+	report, _, err := Report(reporterID, TargetPost, int(postID), "spam", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report.CaseID
+}
+
+func count(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Moderators acting on one case at once: only one action is carried out,
+// and the others are told the case is resolved.
+func TestActConcurrently(t *testing.T) {
+	authorID := testdb.NewUser(t)
+	reporterID := testdb.NewUser(t)
+	caseID := reportedPost(t, authorID, reporterID)
+
+	const moderators = 8
+	errs := make(chan error, moderators)
+	var wg sync.WaitGroup
+	for range moderators {
+		moderatorID := testdb.NewUser(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Act(moderatorID, caseID, Action{Action: ActionWarn})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrCaseResolved):
+			t.Errorf("Act: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d moderators acted, want 1", succeeded)
+	}
+	if n := count(t, `SELECT COUNT(*) FROM notifications WHERE user_id = ? AND kind = 'warning'`, authorID); n != 1 {
+		t.Errorf("author warned %d times, want 1", n)
+	}
+	if n := count(t, `SELECT COUNT(*) FROM moderation_log WHERE case_id = ?`, caseID); n != 1 {
+		t.Errorf("%d log entries for the case, want 1", n)
+	}
+}
+
+// An action that fails leaves the case open for another try.
+func TestActFailureReopensCase(t *testing.T) {
+	authorID := testdb.NewUser(t)
+	reporterID := testdb.NewUser(t)
+	moderatorID := testdb.NewUser(t)
+	caseID := reportedPost(t, authorID, reporterID)
+
+	err := Act(moderatorID, caseID, Action{Action: ActionSuspend, Days: MaxSuspendDays + 1})
+	if !errors.Is(err, ErrInvalidDuration) {
+		t.Fatalf("Act: %v, want ErrInvalidDuration", err)
+	}
+	c, err := db.GetCase(caseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != db.CaseOpen {
+		t.Errorf("case is %s after a failed action", c.Status)
+	}
+	if n := count(t, `SELECT COUNT(*) FROM moderation_log WHERE case_id = ?`, caseID); n != 0 {
+		t.Errorf("%d log entries for a failed action", n)
+	}
+
+	if err := Act(moderatorID, caseID, Action{Action: ActionSuspend, Days: 3}); err != nil {
+		t.Fatalf("retrying: %v", err)
+	}
+	if n := count(t, `SELECT COUNT(*) FROM user_sanctions WHERE user_id = ?`, authorID); n != 1 {
+		t.Errorf("%d sanctions, want 1", n)
+	}
+}
@@ -0,0 +1,60 @@
+package notifications
+
+import (
+	"real/db"
+	"real/models"
+	"real/realtime"
+)
+
+// Kinds of notification.
+const (
+	KindMention = "mention"
+	KindReply   = "reply"
+	KindLike    = "like"
+	KindMessage = "message"
+	KindWarning = "warning"
+)
+
+// Notify records a notification for userID about something actorID did to a
+// post, comment or message, and pushes it to the user's open connections.
+// Users are never notified about their own actions, nor about anything done
+// by users they blocked.
+func Notify(userID, actorID int, kind, targetType string, targetID int) error {
+	if userID == actorID {
+		return nil
+	}
+	blocked, err := db.IsBlocked(userID, actorID)
+	if err != nil || blocked {
+		return err
+	}
+
+	n := models.Notification{
+		UserID:     userID,
+		ActorID:    actorID,
+		Kind:       kind,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	if err := db.CreateNotification(&n); err != nil {
+		return err
+	}
+
+	if realtime.IsOnline(userID) {
+		stored, err := db.GetNotification(n.NotificationID)
+		if err != nil {
+			return err
+		}
+		realtime.SendToUser(userID, realtime.EventNotification, stored)
+	}
+	return nil
+}
+
+// NotifyOnce is Notify for actions that can be undone and redone, such as
+// likes: the user hears about them the first time only.
+func NotifyOnce(userID, actorID int, kind, targetType string, targetID int) error {
+	exists, err := db.NotificationExists(userID, actorID, kind, targetType, targetID)
+	if err != nil || exists {
+		return err
+	}
+	return Notify(userID, actorID, kind, targetType, targetID)
+}
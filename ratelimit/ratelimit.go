@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"real/api"
+	"real/auth"
+	"real/config"
+)
+
+// Actions that are rate limited. Each user has a separate allowance for
+// each one.
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+	ActionVote    = "vote"
+	ActionMessage = "message"
+)
+
+func limitFor(action string) config.RateLimit {
+	limits := config.Current.RateLimits
+	switch action {
+	case ActionPost:
+		return limits.Post
+	case ActionComment:
+		return limits.Comment
+	case ActionVote:
+		return limits.Vote
+	case ActionMessage:
+		return limits.Message
+	}
+	return config.RateLimit{}
+}
+
+type key struct {
+	userID int
+	action string
+}
+
+// bucket holds the tokens a user had left for an action at updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// sweepInterval is how often buckets that have refilled are dropped, so
+// memory does not grow with every user who ever acted.
+const sweepInterval = time.Minute
+
+var buckets = struct {
+	sync.Mutex
+	m         map[key]*bucket
+	lastSweep time.Time
+}{m: make(map[key]*bucket)}
+
+// Allow takes one token from userID's bucket for action. When it is empty,
+// Allow returns false and how long until the next token.
+//
+// Buckets live in memory, so with several processes each one enforces the
+// limits on its own.
+func Allow(userID int, action string) (bool, time.Duration) {
+	limit := limitFor(action)
+	if limit.Burst == 0 {
+		return true, 0
+	}
+	rate := limit.PerMinute / float64(time.Minute)
+	now := time.Now()
+
+	buckets.Lock()
+	defer buckets.Unlock()
+
+	if now.Sub(buckets.lastSweep) > sweepInterval {
+		sweep(now)
+	}
+
+	k := key{userID, action}
+	b, ok := buckets.m[k]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		buckets.m[k] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(now.Sub(b.updated))*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate)
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that are full again. They behave the same as no
+// bucket at all.
+func sweep(now time.Time) {
+	for k, b := range buckets.m {
+		limit := limitFor(k.action)
+		rate := limit.PerMinute / float64(time.Minute)
+		if limit.Burst == 0 || b.tokens+float64(now.Sub(b.updated))*rate >= float64(limit.Burst) {
+			delete(buckets.m, k)
+		}
+	}
+	buckets.lastSweep = now
+}
+
+// RetryAfterSeconds rounds a wait up to whole seconds, the unit of the
+// Retry-After header.
+func RetryAfterSeconds(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// Middleware limits how often signed-in users can call next for action.
+// Once they run out, it answers 429 with a Retry-After header. Guests are
+// let through for next to turn away. It must run inside SessionMiddleware.
+func Middleware(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.CurrentUserID(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed, wait := Allow(userID, action); !allowed {
+			seconds := RetryAfterSeconds(wait)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			api.WriteError(w, api.NewError(http.StatusTooManyRequests, "Too many requests, try again later").
+				WithDetails(map[string]string{"retry_after": strconv.Itoa(seconds)}))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,38 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+
+	"real/db"
+)
+
+// hiddenFrom returns the users who must not get an event for everyone
+// because of a block or mute. Presence is kept from the users its subject
+// blocked, and new posts from the users who blocked or muted the author.
+// When the lists cannot be read the event is kept from everyone rather than
+// leaked.
+func hiddenFrom(eventType string, payload []byte) (hidden map[int]bool, hideAll bool) {
+	var lookup func(int) (map[int]bool, error)
+	switch eventType {
+	case EventPresence:
+		lookup = db.BlockedUserIDs
+	case EventPostCreated:
+		lookup = db.HidingUserIDs
+	default:
+		return nil, false
+	}
+
+	var about struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.Unmarshal(payload, &about); err != nil || about.UserID == 0 {
+		return nil, false
+	}
+	hidden, err := lookup(about.UserID)
+	if err != nil {
+		log.Printf("Error reading blocks for %s event: %v", eventType, err)
+		return nil, true
+	}
+	return hidden, false
+}
@@ -0,0 +1,98 @@
+package realtime
+
+import (
+	"sort"
+	"sync"
+)
+
+// Envelope is an event on its way to the connections that should get it.
+type Envelope struct {
+	// ID is the event's ID in the events table, or 0 for events that are
+	// not kept for replay.
+	ID int64
+	// UserID is the user the event is for, or 0 for everyone.
+	UserID  int
+	Type    string
+	Payload []byte // JSON encoded event data
+}
+
+// A Broker carries events and presence between every forum process that
+// serves real-time connections. Events reach each process's connections
+// through the function passed to Subscribe, including on the process that
+// published them.
+type Broker interface {
+	// Subscribe sets the function that delivers events to this process's
+	// connections. It is called once, before anything is published.
+	Subscribe(deliver func(Envelope))
+	// Publish sends an event to every process. Events with an ID are
+	// already in the events table.
+	Publish(e Envelope) error
+
+	// Connected and Disconnected record a connection of userID opening or
+	// closing on this process. They report whether that changed whether the
+	// user is online anywhere.
+	Connected(userID int) (cameOnline bool, err error)
+	Disconnected(userID int) (wentOffline bool, err error)
+
+	// IsOnline and OnlineUserIDs combine the connections of every process.
+	IsOnline(userID int) bool
+	OnlineUserIDs() []int
+}
+
+// memoryBroker is the Broker for a single process: events go straight to
+// the local connections.
+type memoryBroker struct {
+	deliver func(Envelope)
+
+	mu          sync.Mutex
+	connections map[int]int
+}
+
+func NewMemoryBroker() Broker {
+	return &memoryBroker{connections: make(map[int]int)}
+}
+
+func (b *memoryBroker) Subscribe(deliver func(Envelope)) {
+	b.deliver = deliver
+}
+
+func (b *memoryBroker) Publish(e Envelope) error {
+	b.deliver(e)
+	return nil
+}
+
+func (b *memoryBroker) Connected(userID int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connections[userID]++
+	return b.connections[userID] == 1, nil
+}
+
+func (b *memoryBroker) Disconnected(userID int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connections[userID]--
+	if b.connections[userID] > 0 {
+		return false, nil
+	}
+	delete(b.connections, userID)
+	return true, nil
+}
+
+func (b *memoryBroker) IsOnline(userID int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connections[userID] > 0
+}
+
+func (b *memoryBroker) OnlineUserIDs() []int {
+	b.mu.Lock()
+	ids := make([]int, 0, len(b.connections))
+	for id := range b.connections {
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,243 @@
+package realtime
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"real/api"
+	"real/auth"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// sendBufferSize bounds each connection's outbound queue. A client that
+	// falls this far behind is disconnected rather than slowing anyone else.
+	sendBufferSize = 256
+	writeWait      = 10 * time.Second
+	maxMessageSize = 8 << 10
+
+	// A WebSocket that has not answered a ping within pongWait is dead.
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+
+	// maxReplay is the most events replayed on reconnect. A client that
+	// missed more is told to resync instead.
+	maxReplay = 500
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// Client is one open real-time connection: a WebSocket, or an event stream
+// from ServeSSE, which has no conn.
+type Client struct {
+	userID int
+	conn   *websocket.Conn
+	send   chan outbound
+
+	// done is closed when the client is evicted.
+	done      chan struct{}
+	evictOnce sync.Once
+}
+
+func newClient(userID int, conn *websocket.Conn) *Client {
+	return &Client{
+		userID: userID,
+		conn:   conn,
+		send:   make(chan outbound, sendBufferSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// outbound is an encoded event waiting to be written. id is the event ID, or
+// 0 for events that are not kept for replay. The connection is closed once
+// an outbound marked last is written.
+type outbound struct {
+	id   int64
+	msg  []byte
+	last bool
+}
+
+// enqueue hands a message to the client's writer without blocking the
+// caller. A client whose queue is full is evicted: it would only fall
+// further behind, and it can catch up through replay once it reconnects.
+func (c *Client) enqueue(out outbound) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- out:
+		stats.eventsQueued.Add(1)
+	default:
+		// Concurrent senders can all find the queue full; only the one that
+		// evicts counts it.
+		if c.evict() {
+			log.Printf("Evicted slow connection for user %d: send queue full", c.userID)
+			stats.slowEvictions.Add(1)
+		}
+	}
+}
+
+// evict disconnects the client, and reports whether this call did it. Its
+// writer stops, and for a WebSocket, closing the connection also ends the
+// reader, which unregisters it.
+func (c *Client) evict() bool {
+	evicted := false
+	c.evictOnce.Do(func() {
+		evicted = true
+		close(c.done)
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+	return evicted
+}
+
+// ServeWS upgrades GET /ws to a WebSocket for the signed-in user. A client
+// reconnecting with ?last_event_id=N first gets every event after N that it
+// missed.
+func ServeWS(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var lastEventID int64
+	resume := r.URL.Query().Has("last_event_id")
+	if resume {
+		n, err := strconv.ParseInt(r.URL.Query().Get("last_event_id"), 10, 64)
+		if err != nil || n < 0 {
+			api.Fail(w, http.StatusBadRequest, "Invalid last_event_id")
+			return
+		}
+		lastEventID = n
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response.
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+
+	c := newClient(userID, conn)
+	// Register before reading the backlog so nothing sent in between is
+	// lost; the writer skips live events the replay already covered.
+	h.register(c)
+
+	go c.writePump(resume, lastEventID)
+	c.readPump()
+}
+
+// readPump reads requests from the client until the connection closes.
+func (c *Client) readPump() {
+	defer func() {
+		h.unregister(c)
+		close(c.send)
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		msgType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("WebSocket heartbeat timeout for user %d", c.userID)
+				stats.heartbeatTimeouts.Add(1)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket read error for user %d: %v", c.userID, err)
+			}
+			return
+		}
+		// Any message shows the client is alive, not just pongs.
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if msgType == websocket.TextMessage {
+			c.dispatch(data)
+		}
+	}
+}
+
+func (c *Client) writePump(resume bool, lastEventID int64) {
+	defer c.conn.Close()
+
+	replayed, err := c.catchUp(resume, lastEventID)
+	if err != nil {
+		log.Printf("WebSocket replay error for user %d: %v", c.userID, err)
+		return
+	}
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case out, ok := <-c.send:
+			if !ok {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if out.id != 0 && out.id <= replayed {
+				continue
+			}
+			if err := c.write(out.msg); err != nil {
+				log.Printf("WebSocket write error for user %d: %v", c.userID, err)
+				return
+			}
+			stats.eventsDelivered.Add(1)
+			if out.last {
+				c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""))
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// catchUp replays the events the client missed since lastEventID, or tells
+// it to resync when they are no longer kept, and then sends the ready event.
+// It returns the ID of the last event replayed.
+func (c *Client) catchUp(resume bool, lastEventID int64) (int64, error) {
+	backlog, latest, err := backlog(c.userID, resume, lastEventID)
+	if err != nil {
+		return 0, err
+	}
+
+	var replayed int64
+	for _, e := range backlog {
+		if err := c.write(e.msg); err != nil {
+			return 0, err
+		}
+		replayed = max(replayed, e.id)
+	}
+	return replayed, c.write(readyEvent(max(latest, replayed)))
+}
+
+func (c *Client) write(msg []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
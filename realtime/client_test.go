@@ -0,0 +1,93 @@
+//go:build sqlite_fts5
+
+package realtime
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A client that stops reading is evicted once its queue fills, while
+// everyone else keeps getting every event.
+func TestSlowClientEvicted(t *testing.T) {
+	const (
+		readers     = 5
+		publishers  = 4
+		perProducer = sendBufferSize/publishers + 20
+		total       = publishers * perProducer
+	)
+
+	slow := newClient(1000, nil)
+	h.register(slow)
+	t.Cleanup(func() { h.unregister(slow) })
+
+	// received counts the broadcasts each reader has seen, by event ID, so a
+	// repeat would not make up for a missed one.
+	received := make([]atomic.Int64, readers)
+	var drained sync.WaitGroup
+	t.Cleanup(drained.Wait)
+	for i := range readers {
+		c := newClient(1001+i, nil)
+		h.register(c)
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			seen := make(map[int64]bool)
+			for {
+				select {
+				case out := <-c.send:
+					var e Event
+					if err := json.Unmarshal(out.msg, &e); err != nil {
+						t.Errorf("decoding %s: %v", out.msg, err)
+						return
+					}
+					if e.Type == EventPostCreated && !seen[e.ID] {
+						seen[e.ID] = true
+						received[i].Add(1)
+					}
+				case <-c.done:
+					return
+				}
+			}
+		}()
+		t.Cleanup(func() {
+			c.evict()
+			h.unregister(c)
+		})
+	}
+
+	evictionsBefore := Snapshot().SlowEvictions
+	var published sync.WaitGroup
+	for p := range publishers {
+		published.Add(1)
+		go func() {
+			defer published.Done()
+			for n := range perProducer {
+				Broadcast(EventPostCreated, map[string]int{"post_id": p*perProducer + n})
+			}
+		}()
+	}
+	published.Wait()
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow client was not evicted")
+	}
+	if got := Snapshot().SlowEvictions - evictionsBefore; got != 1 {
+		t.Errorf("slow evictions went up by %d, want 1", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for i := range received {
+		for received[i].Load() < total && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := received[i].Load(); got != total {
+			t.Errorf("reader %d got %d of %d events", i, got, total)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"real/db"
+	"real/models"
+)
+
+// Event is what gets pushed to connected clients, encoded as
+// {"id": 1, "type": "...", "data": {...}}. Events that are kept for replay
+// carry an increasing ID; the rest, such as presence changes, have none.
+type Event struct {
+	ID   int64       `json:"id,omitempty"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Event types sent by the server.
+const (
+	EventPresence     = "presence"
+	EventNotification = "notification"
+	EventPostCreated  = "post_created"
+	EventMessage      = "message"
+	EventReadReceipt  = "read_receipt"
+	EventConversation = "conversation"
+	// Carries the whole message again after it was edited or deleted.
+	EventMessageUpdated = "message_updated"
+	// The last event on a connection before the server closes it because
+	// the user was suspended or banned.
+	EventSignedOut = "signed_out"
+
+	// Sent on connect, after any replay. Its last_event_id is where the
+	// client should resume from after a reconnect.
+	EventReady = "ready"
+	// Sent instead of a replay when the missed events are no longer kept;
+	// the client should reload its state over HTTP.
+	EventResync = "resync"
+)
+
+// hub tracks the open connections of every signed-in user on this process.
+// A user can have several at once, e.g. one per browser tab.
+type hub struct {
+	mu      sync.RWMutex
+	clients map[int]map[*Client]bool
+}
+
+var h = &hub{clients: make(map[int]map[*Client]bool)}
+
+// broker carries events and presence to every process. It is the in-memory
+// one unless UseBroker picks another.
+var broker Broker
+
+func init() {
+	UseBroker(NewMemoryBroker())
+}
+
+// UseBroker switches the broker events go through. Call it before serving
+// any connections.
+func UseBroker(b Broker) {
+	b.Subscribe(h.deliver)
+	broker = b
+}
+
+func (h *hub) register(c *Client) {
+	h.mu.Lock()
+	if h.clients[c.userID] == nil {
+		h.clients[c.userID] = make(map[*Client]bool)
+	}
+	h.clients[c.userID][c] = true
+	h.mu.Unlock()
+	stats.connections.Add(1)
+
+	cameOnline, err := broker.Connected(c.userID)
+	if err != nil {
+		log.Printf("Error recording presence for user %d: %v", c.userID, err)
+	}
+
+	// Checked only now that the client is registered, so a sanction issued
+	// while it connected either shows up here or signs it out.
+	sanction, err := db.ActiveSanction(c.userID)
+	if err != nil {
+		log.Printf("Error checking sanctions for user %d: %v", c.userID, err)
+	}
+	if sanction != nil {
+		if msg, ok := encode(Event{Type: EventSignedOut, Data: newSignedOut(*sanction)}); ok {
+			c.enqueue(outbound{msg: msg, last: true})
+		}
+		return
+	}
+	if cameOnline {
+		publishEphemeral(EventPresence, presence{UserID: c.userID, Online: true})
+	}
+}
+
+func (h *hub) unregister(c *Client) {
+	h.mu.Lock()
+	conns, ok := h.clients[c.userID]
+	if !ok || !conns[c] {
+		h.mu.Unlock()
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.clients, c.userID)
+	}
+	h.mu.Unlock()
+	stats.connections.Add(-1)
+
+	wentOffline, err := broker.Disconnected(c.userID)
+	if err != nil {
+		log.Printf("Error recording presence for user %d: %v", c.userID, err)
+	}
+	if wentOffline {
+		publishEphemeral(EventPresence, presence{UserID: c.userID, Online: false})
+	}
+}
+
+type presence struct {
+	UserID int  `json:"user_id"`
+	Online bool `json:"online"`
+}
+
+// SendToUser pushes an event to every open connection of one user. The
+// event is kept for replay, so the user gets it after reconnecting even when
+// they are offline right now.
+func SendToUser(userID int, eventType string, data interface{}) {
+	if e, ok := record(userID, eventType, data); ok {
+		publish(e)
+	}
+}
+
+// Broadcast pushes an event to every connected user and keeps it for replay.
+func Broadcast(eventType string, data interface{}) {
+	if e, ok := record(0, eventType, data); ok {
+		publish(e)
+	}
+}
+
+// SignOut closes every open connection of a user who was just suspended or
+// banned, on every process, after telling them why.
+func SignOut(s models.Sanction) {
+	payload, err := json.Marshal(newSignedOut(s))
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", EventSignedOut, err)
+		return
+	}
+	publish(Envelope{UserID: s.UserID, Type: EventSignedOut, Payload: payload})
+}
+
+type signedOut struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func newSignedOut(s models.Sanction) signedOut {
+	return signedOut{Reason: s.Kind, ExpiresAt: s.ExpiresAt}
+}
+
+// publishEphemeral pushes an event to every connected user without keeping
+// it. Used for state that is stale by the time anyone could replay it.
+func publishEphemeral(eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return
+	}
+	publish(Envelope{Type: eventType, Payload: payload})
+}
+
+func publish(e Envelope) {
+	if err := broker.Publish(e); err != nil {
+		log.Printf("Error publishing %s event: %v", e.Type, err)
+	}
+}
+
+// deliver hands an event from the broker to the connections on this process
+// that should get it.
+func (h *hub) deliver(e Envelope) {
+	msg, ok := encode(Event{ID: e.ID, Type: e.Type, Data: json.RawMessage(e.Payload)})
+	if !ok {
+		return
+	}
+	out := outbound{id: e.ID, msg: msg, last: e.Type == EventSignedOut}
+
+	if e.UserID != 0 {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		for c := range h.clients[e.UserID] {
+			c.enqueue(out)
+		}
+		return
+	}
+
+	hidden, hideAll := hiddenFrom(e.Type, e.Payload)
+	if hideAll {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for userID, conns := range h.clients {
+		if hidden[userID] {
+			continue
+		}
+		for c := range conns {
+			c.enqueue(out)
+		}
+	}
+}
+
+// IsOnline reports whether the user has at least one open connection on
+// any process.
+func IsOnline(userID int) bool {
+	return broker.IsOnline(userID)
+}
+
+// OnlineUserIDs returns the IDs of every connected user in ascending order.
+func OnlineUserIDs() []int {
+	return broker.OnlineUserIDs()
+}
+
+// record stores an event for replay under userID (0 for everyone). If it
+// cannot be stored it is still sent live, just without an ID.
+func record(userID int, eventType string, data interface{}) (Envelope, bool) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", eventType, err)
+		return Envelope{}, false
+	}
+
+	id, err := db.CreateEvent(userID, eventType, payload)
+	if err != nil {
+		log.Printf("Error storing %s event: %v", eventType, err)
+	}
+	return Envelope{ID: id, UserID: userID, Type: eventType, Payload: payload}, true
+}
+
+func encode(e Event) ([]byte, bool) {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", e.Type, err)
+		return nil, false
+	}
+	return msg, true
+}
@@ -0,0 +1,119 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"real/ratelimit"
+)
+
+// Reply event types, sent only to the connection that made the request.
+const (
+	EventAck   = "ack"
+	EventError = "error"
+)
+
+// A Handler answers one type of request a client sends over its socket. The
+// result is returned to that connection in an ack event.
+type Handler func(userID int, data json.RawMessage) (interface{}, error)
+
+// ClientError is an error whose text is safe to send back to the client.
+// Any other error a Handler returns is logged and reported as an internal
+// error.
+type ClientError string
+
+func (e ClientError) Error() string { return string(e) }
+
+// route is a registered handler and the rate-limited action its requests
+// count as, if any.
+type route struct {
+	handler Handler
+	action  string
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]route)
+)
+
+// HandleFunc registers the handler for requests of the given type.
+func HandleFunc(requestType string, handler Handler) {
+	HandleLimitedFunc(requestType, "", handler)
+}
+
+// HandleLimitedFunc registers the handler for requests of the given type,
+// which count against the sender's rate limit for action, shared with the
+// HTTP endpoints limited for the same action.
+func HandleLimitedFunc(requestType, action string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[requestType] = route{handler, action}
+}
+
+// request is what a client sends: {"type": "...", "ref": "...", "data": {...}}.
+// ref is chosen by the client and echoed in the reply so it can match the
+// two up.
+type request struct {
+	Type string          `json:"type"`
+	Ref  string          `json:"ref"`
+	Data json.RawMessage `json:"data"`
+}
+
+type ack struct {
+	Ref    string      `json:"ref"`
+	Result interface{} `json:"result"`
+}
+
+type replyError struct {
+	Ref   string `json:"ref"`
+	Error string `json:"error"`
+	// RetryAfter is how many seconds a rate-limited client should wait.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// dispatch runs the handler for one request and queues the reply on c.
+func (c *Client) dispatch(raw []byte) {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		c.reply(EventError, replyError{Error: "Invalid request"})
+		return
+	}
+
+	handlersMu.RLock()
+	route, ok := handlers[req.Type]
+	handlersMu.RUnlock()
+	if !ok {
+		c.reply(EventError, replyError{Ref: req.Ref, Error: "Unknown request type"})
+		return
+	}
+	if route.action != "" {
+		if allowed, wait := ratelimit.Allow(c.userID, route.action); !allowed {
+			c.reply(EventError, replyError{
+				Ref:        req.Ref,
+				Error:      "Too many requests, try again later",
+				RetryAfter: ratelimit.RetryAfterSeconds(wait),
+			})
+			return
+		}
+	}
+
+	result, err := route.handler(c.userID, req.Data)
+	var clientErr ClientError
+	switch {
+	case errors.As(err, &clientErr):
+		c.reply(EventError, replyError{Ref: req.Ref, Error: clientErr.Error()})
+	case err != nil:
+		log.Printf("Error handling %s request from user %d: %v", req.Type, c.userID, err)
+		c.reply(EventError, replyError{Ref: req.Ref, Error: "Internal server error"})
+	default:
+		c.reply(EventAck, ack{Ref: req.Ref, Result: result})
+	}
+}
+
+func (c *Client) reply(eventType string, data interface{}) {
+	if msg, ok := encode(Event{Type: eventType, Data: data}); ok {
+		c.enqueue(outbound{msg: msg})
+	}
+}
@@ -0,0 +1,62 @@
+package realtime
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"real/api"
+)
+
+// stats are the hub's running counters.
+var stats struct {
+	connections       atomic.Int64
+	eventsQueued      atomic.Int64
+	eventsDelivered   atomic.Int64
+	slowEvictions     atomic.Int64
+	heartbeatTimeouts atomic.Int64
+}
+
+// Metrics is a snapshot of the hub's health on this process.
+type Metrics struct {
+	Connections int64 `json:"connections"`
+	OnlineUsers int   `json:"online_users"`
+	// Events waiting in outbound queues right now, in total and in the
+	// fullest single queue.
+	QueuedNow     int `json:"queued_now"`
+	MaxQueueNow   int `json:"max_queue_now"`
+	QueueCapacity int `json:"queue_capacity"`
+
+	EventsQueued      int64 `json:"events_queued"`
+	EventsDelivered   int64 `json:"events_delivered"`
+	SlowEvictions     int64 `json:"slow_evictions"`
+	HeartbeatTimeouts int64 `json:"heartbeat_timeouts"`
+}
+
+// Snapshot returns the current metrics.
+func Snapshot() Metrics {
+	m := Metrics{
+		Connections:       stats.connections.Load(),
+		QueueCapacity:     sendBufferSize,
+		EventsQueued:      stats.eventsQueued.Load(),
+		EventsDelivered:   stats.eventsDelivered.Load(),
+		SlowEvictions:     stats.slowEvictions.Load(),
+		HeartbeatTimeouts: stats.heartbeatTimeouts.Load(),
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	m.OnlineUsers = len(h.clients)
+	for _, conns := range h.clients {
+		for c := range conns {
+			n := len(c.send)
+			m.QueuedNow += n
+			m.MaxQueueNow = max(m.MaxQueueNow, n)
+		}
+	}
+	return m
+}
+
+// MetricsHandler serves the current metrics as JSON.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	api.WriteJSON(w, http.StatusOK, Snapshot())
+}
@@ -0,0 +1,66 @@
+package realtime
+
+import (
+	"encoding/json"
+
+	"real/db"
+)
+
+type ready struct {
+	LastEventID int64 `json:"last_event_id"`
+}
+
+func readyEvent(lastEventID int64) []byte {
+	msg, _ := encode(Event{Type: EventReady, Data: ready{LastEventID: lastEventID}})
+	return msg
+}
+
+// backlog returns the events userID missed after lastEventID, oldest first,
+// and the latest event ID at the time of the call. When resume is false
+// there is nothing to replay. When the missed events cannot all be replayed,
+// because they were pruned or there are too many, the backlog is a single
+// resync event instead.
+func backlog(userID int, resume bool, lastEventID int64) ([]outbound, int64, error) {
+	latest, err := db.LatestEventID()
+	if err != nil || !resume || lastEventID == latest {
+		return nil, latest, err
+	}
+
+	oldest, err := db.OldestEventID()
+	if err != nil {
+		return nil, 0, err
+	}
+	if oldest == 0 {
+		oldest = latest + 1
+	}
+	if lastEventID > latest || lastEventID+1 < oldest {
+		return resync(latest), latest, nil
+	}
+
+	events, err := db.ListEventsSince(userID, lastEventID, maxReplay+1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(events) > maxReplay {
+		return resync(latest), latest, nil
+	}
+
+	out := make([]outbound, 0, len(events))
+	for _, e := range events {
+		if e.UserID == 0 {
+			if hidden, hideAll := hiddenFrom(e.Type, e.Payload); hideAll || hidden[userID] {
+				continue
+			}
+		}
+		msg, ok := encode(Event{ID: e.EventID, Type: e.Type, Data: json.RawMessage(e.Payload)})
+		if ok {
+			out = append(out, outbound{id: e.EventID, msg: msg})
+		}
+	}
+	return out, latest, nil
+}
+
+func resync(latest int64) []outbound {
+	msg, _ := encode(Event{Type: EventResync, Data: ready{LastEventID: latest}})
+	return []outbound{{msg: msg}}
+}
@@ -0,0 +1,197 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"real/db"
+)
+
+const (
+	heartbeatInterval = 10 * time.Second
+	// A process that has not sent a heartbeat for this long is considered
+	// gone, and its connections with it.
+	instanceTimeout = 3 * heartbeatInterval
+	tailBatchSize   = 500
+)
+
+// sqliteBroker shares events between processes that use the same database.
+// Every process tails the events table and delivers what it finds to its own
+// connections, so an event reaches all of them no matter which process
+// published it. Presence is kept in the presence table per process.
+type sqliteBroker struct {
+	instanceID   string
+	pollInterval time.Duration
+	cursor       int64
+	deliver      func(Envelope)
+
+	// mu serializes presence updates with heartbeats, which rewrite this
+	// process's presence rows from connections.
+	mu          sync.Mutex
+	connections map[int]int
+}
+
+// NewSQLiteBroker returns a Broker that checks the events table for new
+// events every pollInterval. Only events published after it starts are
+// delivered; older ones are left to replay.
+func NewSQLiteBroker(pollInterval time.Duration) (Broker, error) {
+	id, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := db.LatestEventID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read latest event: %v", err)
+	}
+	if err := db.SyncInstance(id, nil); err != nil {
+		return nil, fmt.Errorf("failed to register instance: %v", err)
+	}
+
+	return &sqliteBroker{
+		instanceID:   id,
+		pollInterval: pollInterval,
+		cursor:       cursor,
+		connections:  make(map[int]int),
+	}, nil
+}
+
+func newInstanceID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
+
+func (b *sqliteBroker) Subscribe(deliver func(Envelope)) {
+	b.deliver = deliver
+	go b.tail()
+	go b.heartbeat()
+}
+
+// Publish has nothing to do for events with an ID, which every process will
+// find in the events table. Others are stored as ephemeral so the other
+// processes see them too.
+func (b *sqliteBroker) Publish(e Envelope) error {
+	if e.ID != 0 {
+		return nil
+	}
+	_, err := db.CreateEphemeralEvent(e.UserID, e.Type, e.Payload)
+	return err
+}
+
+func (b *sqliteBroker) tail() {
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			events, err := db.ListAllEventsSince(b.cursor, tailBatchSize)
+			if err != nil {
+				log.Printf("Error reading events: %v", err)
+				break
+			}
+			for _, e := range events {
+				env := Envelope{ID: e.EventID, UserID: e.UserID, Type: e.Type, Payload: e.Payload}
+				if e.Ephemeral {
+					env.ID = 0
+				}
+				b.deliver(env)
+				b.cursor = e.EventID
+			}
+			if len(events) < tailBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (b *sqliteBroker) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.mu.Lock()
+		err := db.SyncInstance(b.instanceID, b.connections)
+		b.mu.Unlock()
+		if err != nil {
+			log.Printf("Error sending instance heartbeat: %v", err)
+		}
+
+		// Users whose only connections were on a process that died need an
+		// offline event from someone.
+		userIDs, err := db.RemoveStaleInstances(staleBefore())
+		if err != nil {
+			log.Printf("Error removing stale instances: %v", err)
+			continue
+		}
+		for _, userID := range userIDs {
+			if b.IsOnline(userID) {
+				continue
+			}
+			payload, err := json.Marshal(presence{UserID: userID, Online: false})
+			if err != nil {
+				continue
+			}
+			if err := b.Publish(Envelope{Type: EventPresence, Payload: payload}); err != nil {
+				log.Printf("Error publishing presence: %v", err)
+			}
+		}
+	}
+}
+
+func staleBefore() time.Time {
+	return time.Now().Add(-instanceTimeout)
+}
+
+func (b *sqliteBroker) Connected(userID int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connections[userID]++
+
+	total, err := db.AddPresence(b.instanceID, userID, 1, staleBefore())
+	return total == 1, err
+}
+
+func (b *sqliteBroker) Disconnected(userID int) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connections[userID]--
+	if b.connections[userID] <= 0 {
+		delete(b.connections, userID)
+	}
+
+	total, err := db.AddPresence(b.instanceID, userID, -1, staleBefore())
+	return err == nil && total == 0, err
+}
+
+func (b *sqliteBroker) IsOnline(userID int) bool {
+	online, err := db.IsPresent(userID, staleBefore())
+	if err != nil {
+		log.Printf("Error checking presence: %v", err)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.connections[userID] > 0
+	}
+	return online
+}
+
+func (b *sqliteBroker) OnlineUserIDs() []int {
+	ids, err := db.ListPresentUserIDs(staleBefore())
+	if err != nil {
+		log.Printf("Error listing presence: %v", err)
+		return []int{}
+	}
+	return ids
+}
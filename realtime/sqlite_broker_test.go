@@ -0,0 +1,151 @@
+//go:build sqlite_fts5
+
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"real/db"
+)
+
+// received collects what a broker delivers, as the hub on its process
+// would get it.
+type received struct {
+	mu     sync.Mutex
+	events []Envelope
+}
+
+func (r *received) deliver(e Envelope) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+// wait returns the first event delivered of type eventType with payload,
+// or fails once the deadline passes.
+func (r *received) wait(t *testing.T, eventType string, payload []byte) Envelope {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for _, e := range r.events {
+			if e.Type == eventType && bytes.Equal(e.Payload, payload) {
+				r.mu.Unlock()
+				return e
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s event %s was not delivered", eventType, payload)
+	return Envelope{}
+}
+
+func newTestBroker(t *testing.T) (Broker, *received) {
+	t.Helper()
+	b, err := NewSQLiteBroker(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &received{}
+	b.Subscribe(r.deliver)
+	return b, r
+}
+
+// Two processes on one database are two brokers: what one publishes the
+// other delivers, and a user is online while connected to either.
+func TestSQLiteBrokers(t *testing.T) {
+	a, _ := newTestBroker(t)
+	b, onB := newTestBroker(t)
+	const userID = 2000
+
+	// An event kept for replay is stored before it is published, as record
+	// does.
+	payload := []byte(`{"post_id":1}`)
+	id, err := db.CreateEvent(0, EventPostCreated, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish(Envelope{ID: id, Type: EventPostCreated, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	if e := onB.wait(t, EventPostCreated, payload); e.ID != id {
+		t.Errorf("delivered with ID %d, want %d", e.ID, id)
+	}
+
+	// Ephemeral events reach the other process without an ID.
+	online := []byte(`{"user_id":2000,"online":true}`)
+	if err := a.Publish(Envelope{Type: EventPresence, Payload: online}); err != nil {
+		t.Fatal(err)
+	}
+	if e := onB.wait(t, EventPresence, online); e.ID != 0 {
+		t.Errorf("ephemeral event delivered with ID %d", e.ID)
+	}
+
+	// Reconnecting with the last event ID seen replays what came after it,
+	// for everyone and for the user, but not ephemeral events or events for
+	// someone else.
+	var want []int64
+	for _, to := range []int{0, userID, userID + 1} {
+		id, err := db.CreateEvent(to, EventNotification, []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if to != userID+1 {
+			want = append(want, id)
+		}
+	}
+	replay, _, err := backlog(userID, true, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, out := range replay {
+		var e Event
+		if err := json.Unmarshal(out.msg, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != EventNotification || e.ID != out.id {
+			t.Errorf("replayed %s", out.msg)
+		}
+		got = append(got, out.id)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("replayed events %v after %d, want %v", got, id, want)
+	}
+
+	// Presence combines both processes.
+	steps := []struct {
+		name    string
+		do      func(int) (bool, error)
+		changed bool
+		online  bool
+	}{
+		{"connect on a", a.Connected, true, true},
+		{"connect on b", b.Connected, false, true},
+		{"disconnect from a", a.Disconnected, false, true},
+		{"disconnect from b", b.Disconnected, true, false},
+	}
+	for _, step := range steps {
+		changed, err := step.do(userID)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if changed != step.changed {
+			t.Errorf("%s: changed %v, want %v", step.name, changed, step.changed)
+		}
+		for name, broker := range map[string]Broker{"a": a, "b": b} {
+			if got := broker.IsOnline(userID); got != step.online {
+				t.Errorf("%s: online on %s %v, want %v", step.name, name, got, step.online)
+			}
+			if got := slices.Contains(broker.OnlineUserIDs(), userID); got != step.online {
+				t.Errorf("%s: listed online on %s %v, want %v", step.name, name, got, step.online)
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package realtime
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"real/api"
+	"real/auth"
+)
+
+// keepAliveInterval is how often an idle event stream gets a comment line,
+// so proxies do not close it for inactivity.
+const keepAliveInterval = 25 * time.Second
+
+// ServeSSE serves GET /api/events, the same events as ServeWS as a
+// text/event-stream for clients that cannot use WebSockets. Each event's data
+// line holds the same JSON object a WebSocket client receives. Resuming works
+// through the Last-Event-ID header that EventSource sends on reconnect, or a
+// last_event_id query parameter. The stream is receive-only; clients send
+// through the HTTP API.
+func ServeSSE(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.CurrentUserID(r)
+	if !ok {
+		api.Fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	resume, lastEventID, ok := sseResumePoint(r)
+	if !ok {
+		api.Fail(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Event stream not supported: %v", err)
+		return
+	}
+
+	c := newClient(userID, nil)
+	h.register(c)
+	defer h.unregister(c)
+
+	backlog, latest, err := backlog(userID, resume, lastEventID)
+	if err != nil {
+		log.Printf("Event stream replay error for user %d: %v", userID, err)
+		return
+	}
+	// Without a deadline a stalled reader would hold this handler forever.
+	extendDeadline := func() { rc.SetWriteDeadline(time.Now().Add(writeWait)) }
+
+	var replayed int64
+	extendDeadline()
+	for _, out := range backlog {
+		if err := writeSSE(w, out); err != nil {
+			return
+		}
+		replayed = max(replayed, out.id)
+	}
+	// Giving ready an ID moves EventSource's resume point even when there was
+	// nothing to replay.
+	cursor := max(latest, replayed)
+	if err := writeSSE(w, outbound{id: cursor, msg: readyEvent(cursor)}); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.done:
+			return
+		case out := <-c.send:
+			if out.id != 0 && out.id <= replayed {
+				continue
+			}
+			extendDeadline()
+			if err := writeSSE(w, out); err != nil {
+				return
+			}
+			stats.eventsDelivered.Add(1)
+			if out.last {
+				rc.Flush()
+				return
+			}
+		case <-keepAlive.C:
+			extendDeadline()
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func sseResumePoint(r *http.Request) (bool, int64, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		if !r.URL.Query().Has("last_event_id") {
+			return false, 0, true
+		}
+		v = r.URL.Query().Get("last_event_id")
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return false, 0, false
+	}
+	return true, n, true
+}
+
+func writeSSE(w http.ResponseWriter, out outbound) error {
+	if out.id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", out.id); err != nil {
+			return err
+		}
+	}
+	// Encoded events are single-line JSON, so one data line is enough.
+	_, err := fmt.Fprintf(w, "data: %s\n\n", out.msg)
+	return err
+}
@@ -174,13 +174,8 @@ function handleLogin() {
         
         if (!response.ok) {
             // Handle validation errors
-            if (data.errors) {
-                for (const [field, message] of Object.entries(data.errors)) {
-                    const errorElement = document.getElementById(`${field}-error`);
-                    if (errorElement) errorElement.textContent = message;
-                }
-            }
-            throw new Error(data.message || 'Login failed');
+            showFieldErrors(data.error);
+            throw new Error(data.error?.message || 'Login failed');
         }
 
         return data;
@@ -193,6 +188,8 @@ function handleLogin() {
             }
             updateAuthUI();
             showPage('home');
+            connectRealtime();
+            loadUnreadNotifications();
         } else {
             throw new Error('Authentication failed');
         }
@@ -202,6 +199,14 @@ function handleLogin() {
         document.getElementById('password-error').textContent = error.message || 'Login failed. Please try again.';
     });
 }
+// Show the per-field messages of an API error next to their fields
+function showFieldErrors(error) {
+    for (const [field, message] of Object.entries(error?.details || {})) {
+        const errorElement = document.getElementById(`${field}-error`);
+        if (errorElement) errorElement.textContent = message;
+    }
+}
+
 // Handle registration
  async function handleRegister() {
     const form = document.getElementById('register-form');
@@ -253,14 +258,9 @@ function handleLogin() {
         const data = await response.json();
 
         if (!response.ok) {
-            if (data.errors) {
-                // Display field-specific errors
-                for (const [field, message] of Object.entries(data.errors)) {
-                    const errorElement = document.getElementById(`${field}-error`);
-                    if (errorElement) errorElement.textContent = message;
-                }
-            }
-            throw new Error(data.error || 'Registration failed');
+            // Display field-specific errors
+            showFieldErrors(data.error);
+            throw new Error(data.error?.message || 'Registration failed');
         }
 
         if (data.success) {
@@ -373,8 +373,9 @@ async function handleCreatePost() {
         });
 
         if (!response.ok) {
-            const errorText = await response.text();
-            throw new Error(errorText || 'Failed to create post');
+            const data = await response.json().catch(() => ({}));
+            const details = data.error?.details;
+            throw new Error((details ? Object.values(details).join('\n') : data.error?.message) || 'Failed to create post');
         }
 
         const data = await response.json();
@@ -394,6 +395,99 @@ async function handleCreatePost() {
     }
 }
 
+// Real-time events
+let socket = null;
+// ID of the last event received, so a reconnect can replay what was missed
+let lastEventId = null;
+// Set once a WebSocket has connected; if none ever does, fall back to SSE
+let socketWorks = false;
+let eventSource = null;
+
+function connectRealtime() {
+    if (socket || eventSource || localStorage.getItem('isAuthenticated') !== 'true') return;
+
+    const protocol = location.protocol === 'https:' ? 'wss' : 'ws';
+    const resume = lastEventId !== null ? `?last_event_id=${lastEventId}` : '';
+    socket = new WebSocket(`${protocol}://${location.host}/ws${resume}`);
+
+    socket.addEventListener('open', () => {
+        socketWorks = true;
+    });
+
+    socket.addEventListener('message', e => receiveRealtimeEvent(e.data));
+
+    socket.addEventListener('close', () => {
+        socket = null;
+        if (!socketWorks) {
+            // Probably a proxy that blocks WebSockets
+            connectEventStream();
+            return;
+        }
+        // Reconnect unless the user logged out
+        setTimeout(connectRealtime, 3000);
+    });
+}
+
+// connectEventStream receives the same events over Server-Sent Events.
+// EventSource reconnects and resumes from the last event ID by itself.
+function connectEventStream() {
+    if (eventSource || localStorage.getItem('isAuthenticated') !== 'true') return;
+
+    const resume = lastEventId !== null ? `?last_event_id=${lastEventId}` : '';
+    eventSource = new EventSource(`/api/events${resume}`);
+    eventSource.addEventListener('message', e => receiveRealtimeEvent(e.data));
+}
+
+function receiveRealtimeEvent(raw) {
+    const event = JSON.parse(raw);
+    if (event.id) lastEventId = Math.max(lastEventId || 0, event.id);
+    handleRealtimeEvent(event);
+}
+
+function handleRealtimeEvent(event) {
+    switch (event.type) {
+        case 'ready':
+            lastEventId = event.data.last_event_id;
+            break;
+        case 'resync':
+            // Too much was missed to replay; reload from the API instead
+            loadUnreadNotifications();
+            break;
+        case 'notification':
+            loadUnreadNotifications();
+            break;
+        case 'signed_out':
+            // Suspended or banned; the server closes the connection next
+            if (eventSource) {
+                eventSource.close();
+                eventSource = null;
+            }
+            alert(event.data.reason === 'ban'
+                ? 'Your account has been banned.'
+                : `Your account has been suspended until ${new Date(event.data.expires_at).toLocaleString()}.`);
+            handleLogout();
+            break;
+        default:
+            console.log('Real-time event:', event);
+    }
+}
+
+async function loadUnreadNotifications() {
+    const badge = document.getElementById('notification-count');
+    if (!badge) return;
+
+    try {
+        const response = await fetch('/api/notifications/unread-count', { credentials: 'include' });
+        if (!response.ok) return;
+
+        const data = await response.json();
+        badge.textContent = data.unread_count;
+        badge.style.display = data.unread_count > 0 ? 'inline-block' : 'none';
+    } catch (error) {
+        console.error('Error loading notifications:', error);
+    }
+}
+
 // Update your DOMContentLoaded event listener
 document.addEventListener('DOMContentLoaded', function() {
     showPage('home');
@@ -401,6 +495,8 @@ document.addEventListener('DOMContentLoaded', function() {
     setupForms();
     updateAuthUI();
     loadCategories(); // Load categories when page loads
+    connectRealtime();
+    loadUnreadNotifications();
     
     // Add this to your existing setupForms function
     const createPostForm = document.getElementById('create-post-form');
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"real/config"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Storage keeps uploaded media. Objects are addressed by keys such as
+// "posts/<name>": slash-separated, with no empty, "." or ".." parts.
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing anything
+	// already there. Readers never see a partly written object.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object under key, or ErrNotFound. The caller closes
+	// it.
+	Open(key string) (io.ReadSeekCloser, Info, error)
+	Stat(key string) (Info, error)
+	// Delete removes the object under key. Deleting a missing object is not
+	// an error.
+	Delete(key string) error
+	// List returns every object whose key starts with prefix.
+	List(prefix string) ([]Info, error)
+	// URL returns a link browsers can load the object from. It may expire.
+	URL(key string) (string, error)
+}
+
+// Info describes a stored object.
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Media is where uploads are kept. It holds the local default until Use
+// swaps it.
+var Media Storage = NewLocal(config.Default().Storage.Local.Root)
+
+// Use makes s the storage for uploads.
+func Use(s Storage) {
+	Media = s
+}
+
+// New returns the backend the configuration asks for.
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Local.Root), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// Key joins the parts of a key.
+func Key(parts ...string) string {
+	return strings.Join(parts, "/")
+}
+
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.Contains(part, `\`) {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy copies every object under prefix from one storage to another,
+// skipping the ones already there with the same size, and reports each
+// object copied to progress. It returns how many were copied.
+func Copy(from, to Storage, prefix string, progress func(key string)) (int, error) {
+	objects, err := from.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	copied := 0
+	for _, obj := range objects {
+		existing, err := to.Stat(obj.Key)
+		if err == nil && existing.Size == obj.Size {
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return copied, err
+		}
+
+		if err := copyObject(from, to, obj.Key); err != nil {
+			return copied, fmt.Errorf("copying %s: %v", obj.Key, err)
+		}
+		copied++
+		if progress != nil {
+			progress(obj.Key)
+		}
+	}
+	return copied, nil
+}
+
+func copyObject(from, to Storage, key string) error {
+	r, info, err := from.Open(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return to.Put(key, r, info.Size, http.DetectContentType(head[:n]))
+}
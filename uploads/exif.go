@@ -0,0 +1,122 @@
+package uploads
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// exifOrientation returns the EXIF orientation of a JPEG, from 1 to 8, or 0
+// when it has none. It reads no further than the start of the image data.
+func exifOrientation(r *bufio.Reader) int {
+	var marker [2]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 0
+	}
+	for {
+		if _, err := io.ReadFull(r, marker[:]); err != nil || marker[0] != 0xFF {
+			return 0
+		}
+		// Start of scan: the headers are over.
+		if marker[1] == 0xDA {
+			return 0
+		}
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return 0
+		}
+		segment := make([]byte, length-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 0
+		}
+		if marker[1] == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+	}
+}
+
+// tiffOrientation finds the orientation tag in the first IFD of the TIFF
+// structure EXIF data is kept in.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		const orientationTag, typeShort = 0x0112, 3
+		if order.Uint16(tiff[entry:]) == orientationTag && order.Uint16(tiff[entry+2:]) == typeShort {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orient turns and flips img from how it was stored to how EXIF
+// orientation says it is shown, so it looks the same once the EXIF data is
+// dropped.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	// from maps a pixel of the result to the pixel of src it comes from.
+	var from func(x, y int) (int, int)
+	dw, dh := w, h
+	switch orientation {
+	case 2: // mirrored
+		from = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // upside down
+		from = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // upside down and mirrored
+		from = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // on its side and mirrored
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return y, x }
+	case 6: // turned a quarter counterclockwise
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // on its other side and mirrored
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // turned a quarter clockwise
+		dw, dh = h, w
+		from = func(x, y int) (int, int) { return w - 1 - y, x }
+	default:
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := from(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,93 @@
+package uploads
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var errBadGIF = errors.New("malformed GIF")
+
+// gifFrames walks the blocks of a GIF without decoding them, and returns
+// how many frames it has and how many pixels they add up to, so
+// animations too large to decode can be turned away first.
+func gifFrames(r *bufio.Reader) (int, int64, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, err
+	}
+	if flags := header[10]; flags&0x80 != 0 {
+		if err := skip(r, colorTableSize(flags)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var (
+		frames int
+		pixels int64
+	)
+	for {
+		block, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		switch block {
+		case 0x21: // extension
+			if _, err := r.ReadByte(); err != nil {
+				return 0, 0, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor
+			desc := make([]byte, 9)
+			if _, err := io.ReadFull(r, desc); err != nil {
+				return 0, 0, err
+			}
+			width := int64(desc[4]) | int64(desc[5])<<8
+			height := int64(desc[6]) | int64(desc[7])<<8
+			frames++
+			pixels += width * height
+			if flags := desc[8]; flags&0x80 != 0 {
+				if err := skip(r, colorTableSize(flags)); err != nil {
+					return 0, 0, err
+				}
+			}
+			// LZW minimum code size, then the image data.
+			if _, err := r.ReadByte(); err != nil {
+				return 0, 0, err
+			}
+			if err := skipSubBlocks(r); err != nil {
+				return 0, 0, err
+			}
+		case 0x3B: // trailer
+			return frames, pixels, nil
+		default:
+			return 0, 0, errBadGIF
+		}
+	}
+}
+
+func colorTableSize(flags byte) int {
+	return 3 << (flags&0x07 + 1)
+}
+
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if err := skip(r, int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+func skip(r *bufio.Reader, n int) error {
+	_, err := r.Discard(n)
+	return err
+}
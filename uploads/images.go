@@ -0,0 +1,287 @@
+package uploads
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"real/storage"
+)
+
+var (
+	ErrInvalidImage = errors.New("file is not a valid image")
+	// ErrImageTooLarge means an image has more pixels or frames than the
+	// policy allows, however small the file.
+	ErrImageTooLarge = errors.New("image dimensions are too large")
+)
+
+// VariantOriginal names the image as it was uploaded.
+const VariantOriginal = "original"
+
+// ImageVariants are the smaller copies made of every post image, each
+// scaled down to fit MaxSide on its longest side. Images that already fit
+// get no copy; the variant is the original.
+var ImageVariants = []struct {
+	Name    string
+	MaxSide int
+}{
+	{"medium", 1024},
+	{"thumbnail", 256},
+}
+
+const jpegQuality = 90
+
+// ImageVariant is one size an image is stored in.
+type ImageVariant struct {
+	Name   string
+	Key    string
+	Width  int
+	Height int
+	Size   int64
+}
+
+// StagedImage is an uploaded image and the copies made of it, all staged
+// until Commit. The original comes first in Variants.
+type StagedImage struct {
+	ContentType string
+	Variants    []ImageVariant
+	staged      []*Staged
+}
+
+// StageImage stages an image that fits the policy under prefix, along with
+// its smaller copies. The image is decoded in full and encoded again, which
+// drops whatever metadata it carried, such as EXIF location. JPEGs are
+// turned the way their EXIF orientation says first. WebP images are stored
+// as JPEG, or as PNG when they have transparency, and GIFs keep their
+// animation while their copies show the first frame.
+func StageImage(prefix string, r io.Reader, p Policy) (*StagedImage, error) {
+	upload, err := Stage(prefix, r, p)
+	if err != nil {
+		return nil, err
+	}
+	defer upload.Discard()
+
+	decoded, err := decodeImage(upload, p)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &StagedImage{}
+	base := uuid.New().String()
+	var original *Staged
+	if decoded.animation != nil {
+		original, err = stageEncoded(prefix, base+".gif", "image/gif", func(w io.Writer) error {
+			return gif.EncodeAll(w, decoded.animation)
+		})
+	} else {
+		original, err = stageStill(prefix, base, decoded.still, stillType(upload.ContentType, decoded.still))
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.ContentType = original.ContentType
+	img.staged = append(img.staged, original)
+
+	width, height := decoded.still.Bounds().Dx(), decoded.still.Bounds().Dy()
+	img.Variants = append(img.Variants, ImageVariant{
+		Name: VariantOriginal, Key: original.Key, Width: width, Height: height, Size: original.Size,
+	})
+
+	// Copies are JPEGs when the original is, and otherwise PNGs, which keep
+	// transparency.
+	variantType := "image/png"
+	if original.ContentType == "image/jpeg" {
+		variantType = "image/jpeg"
+	}
+	for _, v := range ImageVariants {
+		w, h := fit(width, height, v.MaxSide)
+		if w == width && h == height {
+			img.Variants = append(img.Variants, ImageVariant{
+				Name: v.Name, Key: original.Key, Width: width, Height: height, Size: original.Size,
+			})
+			continue
+		}
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), decoded.still, decoded.still.Bounds(), draw.Src, nil)
+
+		staged, err := stageStill(prefix, base+"-"+v.Name, scaled, variantType)
+		if err != nil {
+			img.Discard()
+			return nil, err
+		}
+		img.staged = append(img.staged, staged)
+		img.Variants = append(img.Variants, ImageVariant{
+			Name: v.Name, Key: staged.Key, Width: w, Height: h, Size: staged.Size,
+		})
+	}
+	return img, nil
+}
+
+// decodedImage is an upload decoded in full. animation is set for GIFs,
+// and still is then their first frame at full size.
+type decodedImage struct {
+	still     image.Image
+	animation *gif.GIF
+}
+
+// decodeImage checks an upload's dimensions against the policy, then
+// decodes it. The format must be the one its contents were sniffed as.
+func decodeImage(s *Staged, p Policy) (decodedImage, error) {
+	f, err := os.Open(s.temp)
+	if err != nil {
+		return decodedImage{}, err
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if err != nil || "image/"+format != s.ContentType {
+		return decodedImage{}, ErrInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return decodedImage{}, ErrInvalidImage
+	}
+	if config.Width > p.MaxWidth || config.Height > p.MaxHeight ||
+		int64(config.Width)*int64(config.Height) > p.MaxPixels {
+		return decodedImage{}, ErrImageTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return decodedImage{}, err
+	}
+
+	if format == "gif" {
+		frames, pixels, err := gifFrames(bufio.NewReader(f))
+		if err != nil {
+			return decodedImage{}, ErrInvalidImage
+		}
+		if frames > p.MaxFrames || pixels > p.MaxPixels {
+			return decodedImage{}, ErrImageTooLarge
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return decodedImage{}, err
+		}
+		g, err := gif.DecodeAll(f)
+		if err != nil || len(g.Image) == 0 {
+			return decodedImage{}, ErrInvalidImage
+		}
+		// Frames can cover just part of the image.
+		first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		draw.Draw(first, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Src)
+		return decodedImage{still: first, animation: g}, nil
+	}
+
+	var orientation int
+	if format == "jpeg" {
+		orientation = exifOrientation(bufio.NewReader(f))
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return decodedImage{}, err
+		}
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return decodedImage{}, ErrInvalidImage
+	}
+	return decodedImage{still: orient(img, orientation)}, nil
+}
+
+// stillType is what a still image is stored as: JPEGs and PNGs as they
+// came, and anything else as a PNG when it has transparency and a JPEG
+// when it does not.
+func stillType(contentType string, img image.Image) string {
+	switch contentType {
+	case "image/jpeg", "image/png":
+		return contentType
+	}
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// stageStill stages img encoded as contentType, a JPEG or a PNG.
+func stageStill(prefix, base string, img image.Image, contentType string) (*Staged, error) {
+	if contentType == "image/jpeg" {
+		return stageEncoded(prefix, base+".jpg", contentType, func(w io.Writer) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+		})
+	}
+	return stageEncoded(prefix, base+".png", "image/png", func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+}
+
+// stageEncoded stages what encode writes, to be stored under prefix and
+// name.
+func stageEncoded(prefix, name, contentType string, encode func(io.Writer) error) (*Staged, error) {
+	f, err := createStagingFile()
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	err = encode(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(f.Name())
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &Staged{
+		File: File{Name: name, Key: storage.Key(prefix, name), ContentType: contentType, Size: info.Size()},
+		temp: f.Name(),
+	}, nil
+}
+
+// fit scales width and height down, keeping their ratio, until neither is
+// over maxSide.
+func fit(width, height, maxSide int) (int, int) {
+	if width <= maxSide && height <= maxSide {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// Commit stores every size of the image. When one fails, the ones already
+// stored are removed again.
+func (s *StagedImage) Commit() error {
+	for i, staged := range s.staged {
+		if err := staged.Commit(); err != nil {
+			for _, done := range s.staged[:i] {
+				if rmErr := storage.Media.Delete(done.Key); rmErr != nil {
+					log.Printf("Error removing image %s: %v", done.Key, rmErr)
+				}
+			}
+			s.Discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// Discard removes the local copies, so it can be deferred right after
+// StageImage.
+func (s *StagedImage) Discard() {
+	for _, staged := range s.staged {
+		staged.Discard()
+	}
+}
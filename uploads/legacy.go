@@ -0,0 +1,24 @@
+package uploads
+
+import (
+	"log"
+
+	"real/storage"
+)
+
+// LegacyPostImagesDir is where post images were kept before media storage,
+// under PostImagesPrefix as in media storage.
+const LegacyPostImagesDir = "static/images"
+
+// ImportLegacyPostImages copies the post images under dir into media
+// storage, where the attachments the database migration made of them look
+// for them. Images already there are skipped, so it runs on every start and
+// does nothing once they have all been copied. The originals are left in
+// place.
+func ImportLegacyPostImages(dir string) error {
+	n, err := storage.Copy(storage.NewLocal(dir), storage.Media, PostImagesPrefix+"/", nil)
+	if n > 0 {
+		log.Printf("Copied %d post images from %s into media storage", n, dir)
+	}
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build sqlite_fts5
+
+package uploads
+
+import (
+	"testing"
+
+	"real/internal/testdb"
+)
+
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
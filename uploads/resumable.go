@@ -0,0 +1,307 @@
+package uploads
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"real/config"
+	"real/db"
+	"real/models"
+)
+
+// What a resumable upload will be used for, which decides the policy it
+// has to fit.
+const (
+	PurposePostImage  = "post_image"
+	PurposeAttachment = "attachment"
+)
+
+var Purposes = map[string]Policy{
+	PurposePostImage:  PostImages,
+	PurposeAttachment: Attachments,
+}
+
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrUnknownPurpose = errors.New("unknown upload purpose")
+	ErrTooManyUploads = errors.New("too many uploads in progress")
+	// ErrOffsetMismatch means data was sent for another offset than the
+	// one the upload has reached.
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	// ErrUploadBusy means another request is already sending data for the
+	// upload.
+	ErrUploadBusy       = errors.New("upload is receiving data in another request")
+	ErrUploadIncomplete = errors.New("upload is not finished")
+)
+
+// busy holds the IDs of the resumable uploads receiving data right now.
+var (
+	busyMu sync.Mutex
+	busy   = make(map[string]bool)
+)
+
+func lockUpload(uploadID string) bool {
+	busyMu.Lock()
+	defer busyMu.Unlock()
+	if busy[uploadID] {
+		return false
+	}
+	busy[uploadID] = true
+	return true
+}
+
+func unlockUpload(uploadID string) {
+	busyMu.Lock()
+	delete(busy, uploadID)
+	busyMu.Unlock()
+}
+
+func resumablePath(uploadID string) string {
+	return filepath.Join(config.Current.Uploads.ResumableDir, uploadID)
+}
+
+func resumableExpiry() time.Time {
+	return time.Now().Add(time.Duration(config.Current.Uploads.ResumableExpiryHours) * time.Hour)
+}
+
+// CreateResumable starts a resumable upload of size bytes for purpose. The
+// data is then sent with AppendResumable, in as many parts as it takes.
+func CreateResumable(userID int, purpose, filename string, size int64) (models.Upload, error) {
+	p, ok := Purposes[purpose]
+	if !ok {
+		return models.Upload{}, ErrUnknownPurpose
+	}
+	if size <= 0 || size > p.MaxSize {
+		return models.Upload{}, ErrTooLarge
+	}
+
+	open, err := db.CountUploads(userID, time.Now())
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if open >= config.Current.Uploads.MaxOpenResumable {
+		return models.Upload{}, ErrTooManyUploads
+	}
+
+	if err := os.MkdirAll(config.Current.Uploads.ResumableDir, 0o755); err != nil {
+		return models.Upload{}, err
+	}
+	u := models.Upload{
+		UploadID:  uuid.New().String(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Filename:  filename,
+		Size:      size,
+		CreatedAt: time.Now(),
+		ExpiresAt: resumableExpiry(),
+	}
+	f, err := os.Create(resumablePath(u.UploadID))
+	if err != nil {
+		return models.Upload{}, err
+	}
+	f.Close()
+
+	if err := db.CreateUpload(u); err != nil {
+		os.Remove(resumablePath(u.UploadID))
+		return models.Upload{}, err
+	}
+	return u, nil
+}
+
+// GetResumable returns one of the user's resumable uploads that has not
+// expired.
+func GetResumable(userID int, uploadID string) (models.Upload, error) {
+	u, err := db.GetUpload(uploadID)
+	if errors.Is(err, db.ErrUploadNotFound) {
+		return models.Upload{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if u.UserID != userID || !u.ExpiresAt.After(time.Now()) {
+		return models.Upload{}, ErrUploadNotFound
+	}
+	return u, nil
+}
+
+// AppendResumable writes what r yields to an upload, starting at offset,
+// which must be where the upload has got to. Whatever arrives before r
+// fails is kept, so the client can go on from the offset the returned
+// upload has reached. Data past the upload's size is refused with
+// ErrTooLarge, and once enough has arrived to tell the file's type, a type
+// the purpose does not allow with ErrUnsupportedType; either way nothing
+// from that request is kept.
+func AppendResumable(userID int, uploadID string, offset int64, r io.Reader) (models.Upload, error) {
+	if !lockUpload(uploadID) {
+		return models.Upload{}, ErrUploadBusy
+	}
+	defer unlockUpload(uploadID)
+
+	u, err := GetResumable(userID, uploadID)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if offset != u.Received {
+		return u, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(resumablePath(uploadID), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return u, err
+	}
+	defer f.Close()
+	// Drop anything a request that died before recording it left past the
+	// offset.
+	if err := f.Truncate(offset); err != nil {
+		return u, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return u, err
+	}
+
+	remaining := u.Size - offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		return u, discardFrom(f, offset, ErrTooLarge)
+	}
+	if copyErr == nil {
+		copyErr = checkResumableType(f, u, offset+n)
+	}
+	if errors.Is(copyErr, ErrUnsupportedType) {
+		return u, discardFrom(f, offset, copyErr)
+	}
+	if err := f.Sync(); err != nil {
+		return u, err
+	}
+
+	u.Received = offset + n
+	u.ExpiresAt = resumableExpiry()
+	if err := db.AdvanceUpload(uploadID, u.Received, u.ExpiresAt); err != nil {
+		return u, err
+	}
+	return u, copyErr
+}
+
+// discardFrom cuts f back to offset and returns err.
+func discardFrom(f *os.File, offset int64, err error) error {
+	if truncErr := f.Truncate(offset); truncErr != nil {
+		return truncErr
+	}
+	return err
+}
+
+// checkResumableType sniffs the type of an upload once the part that
+// decides it has arrived, so a file that will be refused is not sent in
+// full first.
+func checkResumableType(f *os.File, u models.Upload, received int64) error {
+	sniffLen := min(u.Size, 512)
+	if u.Received >= sniffLen || received < sniffLen {
+		return nil
+	}
+	head := make([]byte, sniffLen)
+	if _, err := f.ReadAt(head, 0); err != nil {
+		return err
+	}
+	if _, ok := Purposes[u.Purpose].Types[http.DetectContentType(head)]; !ok {
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// OpenResumable opens the data of one of the user's finished uploads for
+// purpose. The caller closes it.
+func OpenResumable(userID int, uploadID, purpose string) (models.Upload, *os.File, error) {
+	u, err := GetResumable(userID, uploadID)
+	if err != nil {
+		return models.Upload{}, nil, err
+	}
+	if u.Purpose != purpose {
+		return models.Upload{}, nil, ErrUploadNotFound
+	}
+	if u.Received < u.Size {
+		return models.Upload{}, nil, ErrUploadIncomplete
+	}
+	f, err := os.Open(resumablePath(uploadID))
+	if errors.Is(err, os.ErrNotExist) {
+		return models.Upload{}, nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return models.Upload{}, nil, err
+	}
+	return u, f, nil
+}
+
+// RemoveResumableData removes the data of an upload but keeps its record,
+// for uploads whose record says what they became.
+func RemoveResumableData(uploadID string) {
+	if err := os.Remove(resumablePath(uploadID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing upload %s: %v", uploadID, err)
+	}
+}
+
+// RemoveResumable removes an upload and its data.
+func RemoveResumable(uploadID string) error {
+	if err := db.DeleteUpload(uploadID); err != nil {
+		return err
+	}
+	RemoveResumableData(uploadID)
+	return nil
+}
+
+// SweepResumable removes the uploads that expired, and data files no
+// upload refers to that are older than an upload can stay idle.
+func SweepResumable() error {
+	expired, err := db.ListExpiredUploads(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if err := RemoveResumable(id); err != nil {
+			return err
+		}
+		log.Printf("Removed expired upload %s", id)
+	}
+
+	entries, err := os.ReadDir(config.Current.Uploads.ResumableDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-time.Duration(config.Current.Uploads.ResumableExpiryHours) * time.Hour)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if _, err := db.GetUpload(e.Name()); !errors.Is(err, db.ErrUploadNotFound) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(config.Current.Uploads.ResumableDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScheduleResumableSweep calls SweepResumable every interval. It blocks,
+// so run it in its own goroutine.
+func ScheduleResumableSweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := SweepResumable(); err != nil {
+			log.Printf("error: resumable upload sweep failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package uploads
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"real/db"
+	"real/storage"
+)
+
+// PostImagesPrefix is the storage prefix images attached to posts are kept
+// under, with every variant of each. Post attachments refer to them by key.
+const PostImagesPrefix = "posts"
+
+// SweepPostImages removes stored post images no attachment refers to, and
+// uploads left staged. Anything younger than grace is left alone, since an
+// upload in progress is staged before its post is committed and stored
+// after. An attachment whose image is missing is only logged: the object
+// may not have been copied into place yet, and a listing that came back
+// short must not cost any post its images.
+func SweepPostImages(grace time.Duration) error {
+	images, err := db.ListPostImages()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool, len(images))
+	for _, img := range images {
+		referenced[img.Key] = true
+	}
+
+	objects, err := storage.Media.List(PostImagesPrefix + "/")
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-grace)
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = true
+		if referenced[obj.Key] || obj.ModTime.After(cutoff) {
+			continue
+		}
+		if err := storage.Media.Delete(obj.Key); err != nil {
+			return err
+		}
+		log.Printf("Removed orphaned post image %s", obj.Key)
+	}
+
+	missing := make(map[int64]bool)
+	for _, img := range images {
+		if stored[img.Key] || img.CreatedAt.After(cutoff) || missing[img.AttachmentID] {
+			continue
+		}
+		missing[img.AttachmentID] = true
+		log.Printf("Post %d refers to missing image %s", img.PostID, img.Key)
+	}
+
+	return sweepStaging(cutoff)
+}
+
+// sweepStaging removes staged uploads older than cutoff, which a request
+// that died before committing or discarding them left behind.
+func sweepStaging(cutoff time.Time) error {
+	entries, err := os.ReadDir(StagingDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(StagingDir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchedulePostImageSweep runs SweepPostImages every interval.
+func SchedulePostImageSweep(interval, grace time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := SweepPostImages(grace); err != nil {
+			log.Printf("error: post image sweep failed: %v", err)
+		}
+	}
+}
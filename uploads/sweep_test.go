@@ -0,0 +1,198 @@
+//go:build sqlite_fts5
+
+package uploads
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"real/db"
+	"real/internal/testdb"
+	"real/storage"
+)
+
+// attachmentCount counts the attachments of a post.
+func attachmentCount(t *testing.T, postID int64) int {
+	t.Helper()
+	var n int
+	if err := db.DB.QueryRow(`SELECT COUNT(*) FROM post_attachments WHERE post_id = ?`, postID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// postWithImage creates a post with one image, stored under key, attached
+// age ago, and returns the post's ID.
+func postWithImage(t *testing.T, key string, age time.Duration) int64 {
+	t.Helper()
+	result, err := db.DB.Exec(
+		`INSERT INTO posts (user_id, title, content) VALUES (?, 'Pictured', 'A post with an image')`, testdb.NewUser(t),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+	result, err = db.DB.Exec(
+		`INSERT INTO post_attachments (post_id, position, content_type, created_at) VALUES (?, 0, 'image/png', ?)`,
+		postID, time.Now().Add(-age),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentID, _ := result.LastInsertId()
+	if _, err := db.DB.Exec(
+		`INSERT INTO post_attachment_variants (attachment_id, variant, storage_key, width, height, size)
+		VALUES (?, 'original', ?, 16, 16, 5)`,
+		attachmentID, key,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return postID
+}
+
+// TestSweepWithEmptyStorage sweeps a store that has none of the images, as
+// when it points at the wrong bucket. Posts keep their images all the same.
+func TestSweepWithEmptyStorage(t *testing.T) {
+	StagingDir = t.TempDir()
+	media := storage.Media
+	storage.Use(storage.NewLocal(t.TempDir()))
+	t.Cleanup(func() { storage.Use(media) })
+
+	postID := postWithImage(t, storage.Key(PostImagesPrefix, uuid.New().String()+".png"), 48*time.Hour)
+	if err := SweepPostImages(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := attachmentCount(t, postID); n != 1 {
+		t.Fatalf("post has %d attachments after the sweep, want 1", n)
+	}
+}
+
+// TestSweepKeepsLegacyImages upgrades a post from before media storage and
+// sweeps before its image has been copied into place. The attachment has to
+// survive, and find its image once it is copied.
+func TestSweepKeepsLegacyImages(t *testing.T) {
+	StagingDir = t.TempDir()
+	name := uuid.New().String() + ".png"
+	result, err := db.DB.Exec(
+		`INSERT INTO posts (user_id, title, content, imgurl, created_at) VALUES (?, 'Legacy', 'From before media storage', ?, ?)`,
+		testdb.NewUser(t), "/images/posts/"+name, time.Now().Add(-48*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+
+	testdb.Reopen(t)
+	if n := attachmentCount(t, postID); n != 1 {
+		t.Fatalf("post has %d attachments after the migration, want 1", n)
+	}
+	var variants []string
+	rows, err := db.DB.Query(`
+		SELECT v.variant FROM post_attachment_variants v
+		JOIN post_attachments a ON a.attachment_id = v.attachment_id WHERE a.post_id = ?`, postID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if len(variants) != 1 || variants[0] != "original" {
+		t.Errorf("migrated image has variants %v, want only original", variants)
+	}
+
+	if err := SweepPostImages(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := attachmentCount(t, postID); n != 1 {
+		t.Fatalf("post has %d attachments after the sweep, want 1", n)
+	}
+
+	legacy := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(legacy, PostImagesPrefix), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, PostImagesPrefix, name), []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportLegacyPostImages(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if err := SweepPostImages(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Media.Stat(storage.Key(PostImagesPrefix, name)); err != nil {
+		t.Fatalf("copied image: %v", err)
+	}
+	if n := attachmentCount(t, postID); n != 1 {
+		t.Fatalf("post has %d attachments after the copy, want 1", n)
+	}
+}
+
+// TestSweepPostImages sweeps stored and staged files on both sides of the
+// grace period. Only the old ones nothing refers to are removed.
+func TestSweepPostImages(t *testing.T) {
+	StagingDir = t.TempDir()
+	root := t.TempDir()
+	media := storage.Media
+	storage.Use(storage.NewLocal(root))
+	t.Cleanup(func() { storage.Use(media) })
+
+	old := time.Now().Add(-2 * time.Hour)
+	store := func(name string, modTime time.Time) string {
+		key := storage.Key(PostImagesPrefix, name)
+		if err := storage.Media.Put(key, strings.NewReader("image"), 5, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(root, PostImagesPrefix, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	stage := func(name string, modTime time.Time) string {
+		path := filepath.Join(StagingDir, name)
+		if err := os.WriteFile(path, []byte("upload"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	orphan := store("orphan.png", old)
+	recent := store("recent.png", time.Now())
+	referenced := store("referenced.png", old)
+	postWithImage(t, referenced, 2*time.Hour)
+	abandoned := stage("upload-abandoned", old)
+	pending := stage("upload-pending", time.Now())
+
+	if err := SweepPostImages(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Media.Stat(orphan); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("orphaned image: got %v, want it removed", err)
+	}
+	for _, key := range []string{recent, referenced} {
+		if _, err := storage.Media.Stat(key); err != nil {
+			t.Errorf("%s: %v, want it kept", key, err)
+		}
+	}
+	if _, err := os.Stat(abandoned); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("abandoned upload: got %v, want it removed", err)
+	}
+	if _, err := os.Stat(pending); err != nil {
+		t.Errorf("pending upload: %v, want it kept", err)
+	}
+}
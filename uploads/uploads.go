@@ -0,0 +1,187 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"real/storage"
+)
+
+var (
+	ErrTooLarge        = errors.New("file is too large")
+	ErrUnsupportedType = errors.New("file type is not allowed")
+)
+
+// A Policy says which files may be uploaded somewhere.
+type Policy struct {
+	MaxSize int64
+	// Types maps each allowed content type, as http.DetectContentType
+	// reports it, to the extension files of that type are saved with.
+	Types map[string]string
+
+	// The rest only applies to StageImage, and is checked before an image
+	// is decoded. MaxPixels caps the pixels decoded, summed over the
+	// frames of an animation.
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+	MaxFrames int
+}
+
+var (
+	PostImages = Policy{
+		MaxSize: 20 << 20,
+		Types: map[string]string{
+			"image/jpeg": ".jpg",
+			"image/png":  ".png",
+			"image/gif":  ".gif",
+			"image/webp": ".webp",
+		},
+		MaxWidth:  10000,
+		MaxHeight: 10000,
+		MaxPixels: 50_000_000,
+		MaxFrames: 500,
+	}
+
+	Attachments = Policy{
+		MaxSize: 10 << 20,
+		Types: map[string]string{
+			"image/jpeg":                ".jpg",
+			"image/png":                 ".png",
+			"image/gif":                 ".gif",
+			"image/webp":                ".webp",
+			"application/pdf":           ".pdf",
+			"text/plain; charset=utf-8": ".txt",
+			"application/zip":           ".zip",
+		},
+	}
+)
+
+// File is an uploaded file that was saved.
+type File struct {
+	Name        string // name within the prefix it was saved under
+	Key         string // storage key, the prefix and the name
+	ContentType string
+	Size        int64
+}
+
+// StagingDir is where uploads wait on local disk until they are committed
+// to storage. Sweep removes the ones left behind.
+var StagingDir = filepath.Join(os.TempDir(), "forum-uploads")
+
+// Staged is an upload written to a temporary local file. Commit stores it
+// under its key; until then nothing refers to it.
+type Staged struct {
+	File
+	temp string
+	done bool
+}
+
+// Stage writes what r yields to a temporary file, as long as it fits the
+// policy, and names it for storing under prefix. The type is sniffed from
+// the contents, never taken from the name or Content-Type the client sent.
+// Nothing is left behind when the file is refused or cannot be written.
+func Stage(prefix string, r io.Reader, p Policy) (*Staged, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	ext, ok := p.Types[contentType]
+	if !ok || n == 0 {
+		return nil, ErrUnsupportedType
+	}
+
+	dst, err := createStagingFile()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reading one byte past the limit tells a file that is exactly at the
+	// limit apart from one that is over it.
+	src := io.LimitReader(io.MultiReader(bytes.NewReader(head), r), p.MaxSize+1)
+	size, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > p.MaxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return nil, err
+	}
+
+	name := uuid.New().String() + ext
+	return &Staged{
+		File: File{Name: name, Key: storage.Key(prefix, name), ContentType: contentType, Size: size},
+		temp: dst.Name(),
+	}, nil
+}
+
+func createStagingFile() (*os.File, error) {
+	if err := os.MkdirAll(StagingDir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(StagingDir, "upload-*")
+}
+
+// Commit stores the staged file under its key and removes the local copy.
+func (s *Staged) Commit() error {
+	f, err := os.Open(s.temp)
+	if err != nil {
+		return err
+	}
+	err = storage.Media.Put(s.Key, f, s.Size, s.ContentType)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	s.Discard()
+	return nil
+}
+
+// Discard removes the local copy, so it can be deferred right after Stage.
+func (s *Staged) Discard() {
+	if s.done {
+		return
+	}
+	s.done = true
+	if err := os.Remove(s.temp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing staged upload: %v", err)
+	}
+}
+
+// Save stages what r yields and commits it under prefix straight away, for
+// callers that record the file only after it is stored.
+func Save(prefix string, r io.Reader, p Policy) (File, error) {
+	staged, err := Stage(prefix, r, p)
+	if err != nil {
+		return File{}, err
+	}
+	defer staged.Discard()
+
+	if err := staged.Commit(); err != nil {
+		return File{}, err
+	}
+	return staged.File, nil
+}
+
+// Open opens a file that Save stored under prefix.
+func Open(prefix, name string) (io.ReadSeekCloser, storage.Info, error) {
+	if name != path.Base(name) {
+		return nil, storage.Info{}, storage.ErrNotFound
+	}
+	return storage.Media.Open(storage.Key(prefix, name))
+}
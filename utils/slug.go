@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+	slugPattern      = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// Slugify lowercases s and collapses everything that is not a letter or digit
+// into single hyphens, e.g. "Health & Fitness" -> "health-fitness".
+func Slugify(s string) string {
+	s = slugInvalidChars.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-")
+}
+
+// ValidSlug reports whether s is already in the form Slugify produces.
+func ValidSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}